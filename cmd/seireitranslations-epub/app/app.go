@@ -15,20 +15,44 @@
 package app
 
 import (
+	"fmt"
+	"html"
 	"log/slog"
+	"os"
 	"path/filepath"
+	"slices"
+	"time"
 
 	"github.com/ynsta/seireitranslations-epub/internal/assets"
+	"github.com/ynsta/seireitranslations-epub/internal/book"
 	"github.com/ynsta/seireitranslations-epub/internal/config"
+	"github.com/ynsta/seireitranslations-epub/internal/cover"
 	"github.com/ynsta/seireitranslations-epub/internal/downloader"
 	"github.com/ynsta/seireitranslations-epub/internal/epub"
+	"github.com/ynsta/seireitranslations-epub/internal/export"
+	"github.com/ynsta/seireitranslations-epub/internal/imaging"
+	"github.com/ynsta/seireitranslations-epub/internal/manifest"
 	"github.com/ynsta/seireitranslations-epub/internal/processor"
 	"github.com/ynsta/seireitranslations-epub/internal/scraper"
+	"github.com/ynsta/seireitranslations-epub/internal/validate"
 	"github.com/ynsta/seireitranslations-epub/pkg/utils"
 )
 
+// manifestSuffix replaces the EPUB extension in the name of the build manifest
+const manifestSuffix = ".manifest.json"
+
 // Execute runs the main program logic and returns an exit code
 func Execute() int {
+	// Validate existing EPUB files instead of building one
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		return Validate(os.Args[2:])
+	}
+
+	// Update a previously built book with new chapters
+	if len(os.Args) > 1 && os.Args[1] == "update" {
+		return Update(os.Args[2:])
+	}
+
 	// Parse command-line arguments
 	cfg, err := config.ParseCommandLine()
 	if err != nil {
@@ -36,6 +60,56 @@ func Execute() int {
 		return 1
 	}
 
+	return build(cfg, nil, nil)
+}
+
+// Update rebuilds a book from its build manifest, downloading only new or changed posts
+//
+// The first argument is the EPUB or KEPUB to update, optionally preceded by
+// --refresh to download again the posts already in the book; the other
+// arguments override the ones recorded in the manifest.
+func Update(args []string) int {
+	refresh := len(args) > 0 && (args[0] == "--refresh" || args[0] == "-refresh")
+	if refresh {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: seireitranslations-epub update [--refresh] FILE.epub [options]")
+		fmt.Fprintln(os.Stderr, "Posts already in the book are reused as recorded; edited posts are only downloaded again with --refresh.")
+		return 2
+	}
+	filename := args[0]
+
+	previous, err := manifest.Read(config.OutputName(filename, manifestSuffix))
+	if err != nil {
+		slog.Error("Error reading build manifest", "error", err)
+		return 1
+	}
+	images, err := epub.ReadImages(filename)
+	if err != nil {
+		slog.Error("Error reading the book to update", "file", filename, "error", err)
+		return 1
+	}
+
+	// Posts are matched by title and URL only, so edits are only seen when refreshing
+	if refresh {
+		previous.Posts = nil
+	}
+
+	// The recorded arguments are relative to the directory of the first build
+	cfg, err := config.ParseArgs(append(config.ResolvePaths(previous.Args, previous.Dir), args[1:]...))
+	if err != nil {
+		slog.Error("Error parsing recorded arguments", "error", err)
+		return 1
+	}
+
+	return build(cfg, previous, images)
+}
+
+// build builds a book, reusing the posts and images of a previous build if given
+func build(cfg *config.Config, previous *manifest.Manifest, previousImages map[string][]byte) int {
+	var err error
+
 	// Clean up temporary directory at the end, but only in non-debug mode
 	if !cfg.Debug {
 		defer cfg.Cleanup()
@@ -46,26 +120,35 @@ func Execute() int {
 	// Create a downloader
 	dl := downloader.New(cfg.TempDir, cfg.Debug)
 
-	// Create an EPUB generator
-	epubGen := epub.New(epub.Config{
-		Title:      cfg.Title,
-		Author:     cfg.Author,
-		CoverURL:   cfg.CoverURL,
-		OutputFile: cfg.OutputFile,
-		TempDir:    cfg.TempDir,
-		Debug:      cfg.Debug,
+	// Create the book the chapters are assembled into
+	b := book.New(book.Config{
+		Title:    cfg.Title,
+		Author:   cfg.Author,
+		TempDir:  cfg.TempDir,
+		Debug:    cfg.Debug,
+		Metadata: bookMetadata(cfg),
+
+		GatherIllustrations: cfg.Illustrations == "gather",
 	})
 
-	// Download and add the cover image
-	coverData, err := dl.DownloadFile(cfg.CoverURL, "cover"+filepath.Ext(cfg.CoverURL))
+	// Select the theme used if a cover has to be generated
+	coverTheme, err := cover.ThemeByName(cfg.CoverTheme)
 	if err != nil {
-		slog.Error("Error downloading cover image", "error", err)
+		slog.Error("Error selecting cover theme", "error", err)
 		return 1
 	}
 
-	if err := epubGen.AddCover(coverData, cfg.CoverURL); err != nil {
-		slog.Error("Error adding cover image", "error", err)
-		return 1
+	// Download the cover image, falling back to another cover if that fails
+	var coverData []byte
+	coverSource := cfg.CoverURL
+	if previous != nil && cfg.CoverURL != "" && cfg.CoverURL == previous.CoverURL && previousImages[previous.Cover] != nil {
+		coverData = previousImages[previous.Cover]
+	} else if cfg.CoverURL != "" {
+		coverData, err = dl.DownloadFile(cfg.CoverURL, "cover"+filepath.Ext(cfg.CoverURL))
+		if err != nil {
+			slog.Warn("Error downloading cover image, using a fallback cover", "error", err)
+			coverData = nil
+		}
 	}
 
 	// Add CSS stylesheet for consistent formatting using embedded file
@@ -75,24 +158,88 @@ func Execute() int {
 		return 1
 	}
 
-	if err := epubGen.AddCSS(cssContent); err != nil {
-		slog.Error("Error adding CSS", "error", err)
-		return 1
+	b.SetStylesheet(cssContent)
+
+	// Read the volumes of an omnibus, or the single volume of the URL list
+	volumes := []utils.VolumeEntry{{URLListFile: cfg.URLListFile}}
+	if cfg.OmnibusFile != "" {
+		volumes, err = utils.ReadVolumeList(cfg.OmnibusFile)
+		if err != nil {
+			slog.Error("Error reading omnibus file", "error", err)
+			return 1
+		}
+		if len(volumes) == 0 {
+			slog.Error("No volumes found in omnibus file", "file", cfg.OmnibusFile)
+			return 1
+		}
 	}
 
-	// Read the list of URLs
-	urlEntries, err := utils.ReadURLList(cfg.URLListFile)
-	if err != nil {
-		slog.Error("Error reading URL list file", "error", err)
-		return 1
+	// Read the list of URLs of each volume
+	var urlEntries []utils.URLEntry
+	volumeEntries := make([][]utils.URLEntry, len(volumes))
+	for i, volume := range volumes {
+		volumeEntries[i], err = utils.ReadURLList(volume.URLListFile)
+		if err != nil {
+			slog.Error("Error reading URL list file", "error", err)
+			return 1
+		}
+		urlEntries = append(urlEntries, volumeEntries[i]...)
+	}
+
+	// An update without new or changed posts nor new arguments leaves the book as it is;
+	// the recorded arguments are compared with their paths resolved like on update
+	if previous != nil && slices.Equal(cfg.Args, config.ResolvePaths(previous.Args, previous.Dir)) && upToDate(previous, urlEntries) {
+		slog.Info("The book is up to date, no new or changed posts", "revision", previous.Revision)
+		return 0
+	}
+
+	// Derive the identifier from the book configuration so rebuilds keep it;
+	// updates keep the identifier of the first build
+	var identifier string
+	switch {
+	case previous != nil:
+		identifier = previous.Identifier
+	case cfg.Reproducible:
+		parts := []string{cfg.Title, cfg.Author, cfg.Series, cfg.Volume, cfg.Language}
+		for _, entry := range urlEntries {
+			parts = append(parts, entry.URL)
+		}
+		identifier = epub.StableIdentifier(parts...)
+	case cfg.Manifest:
+		identifier = epub.NewIdentifier()
 	}
 
 	// Add attribution chapter as the first chapter
 	slog.Info("Adding attribution chapter")
-	if err := epubGen.AddAttributionChapter("Attribution and Sources", urlEntries); err != nil {
+	if err := b.AddAttributionChapter("Attribution and Sources", urlEntries); err != nil {
 		slog.Warn("Error adding attribution chapter", "error", err)
 	}
 
+	// Load find-and-replace rules if a rules file was provided
+	var replacer *processor.Replacer
+	if cfg.RulesFile != "" {
+		replacer, err = processor.LoadReplacementRules(cfg.RulesFile)
+		if err != nil {
+			slog.Error("Error reading rules file", "error", err)
+			return 1
+		}
+		slog.Info("Loaded replacement rules", "count", len(replacer.Rules()))
+	}
+
+	// Load the glossary if a glossary file was provided
+	var glossary []utils.GlossaryEntry
+	var glossaryLinker *processor.GlossaryLinker
+	if cfg.GlossaryFile != "" {
+		glossary, err = utils.ReadGlossary(cfg.GlossaryFile)
+		if err != nil {
+			slog.Error("Error reading glossary file", "error", err)
+			return 1
+		}
+		if cfg.GlossaryLinks {
+			glossaryLinker = processor.NewGlossaryLinker(glossary)
+		}
+	}
+
 	// Create a scraper
 	s := scraper.New(cfg.TempDir, cfg.Debug)
 
@@ -101,74 +248,418 @@ func Execute() int {
 	htmlProc.SetDebug(cfg.Debug)
 	htmlProc.SetTempDir(cfg.TempDir)
 
-	// Create image processor
-	imgProc := processor.NewImageProcessor(dl, cfg.TempDir, cfg.Debug, epubGen.GetEpub())
+	// Create image processor with the selected image profile
+	imageProfile, err := imaging.ProfileByName(cfg.ImageProfile)
+	if err != nil {
+		slog.Error("Error selecting image profile", "error", err)
+		return 1
+	}
+	imgProc := processor.NewImageProcessor(dl, cfg.TempDir, cfg.Debug, b)
+	imgProc.SetProfile(imageProfile)
+	imgProc.SetBloggerSize(cfg.ImageSize)
+	imgProc.SetAltText(cfg.AltText)
+
+	// Reuse the images of the previous build
+	if previous != nil {
+		for _, img := range previous.Images {
+			if data, ok := previousImages[img.Name]; ok {
+				imgProc.AddEmbedded(img.Name, img.URLs, data)
+			}
+		}
+	}
 
-	// Process each URL
-	var currentChapter *epub.Chapter
+	// Process each URL, volume by volume
 	var chapterIndex int = 1
+	var urlIndex int
+	var firstPublished, lastPublished time.Time
+	var posts []manifest.Post
+	var fetched int
+	var firstIllustration utils.Illustration
+
+	for v, volume := range volumes {
+		// Start each volume of an omnibus with its title page
+		if volume.Title != "" {
+			slog.Info("Starting volume", "index", v+1, "total", len(volumes), "title", volume.Title)
+			b.StartVolume(volume.Title, volumeCover(imgProc, volume))
+		}
+
+		var currentChapter *book.Chapter
+		for _, entry := range volumeEntries[v] {
+			i := urlIndex
+			urlIndex++
+			slog.Info("Processing URL", "index", i+1, "total", len(urlEntries), "title", entry.Title, "url", entry.URL)
+
+			// Download the page, unless the previous build already did
+			var content scraper.Content
+			if post := previous.Find(entry.Title, entry.URL); post != nil {
+				content = scraper.Content{HTML: post.HTML, Published: post.Published}
+			} else {
+				content, err = s.ExtractContent(entry.URL, i)
+				if err != nil {
+					slog.Warn("Error processing URL", "url", entry.URL, "error", err)
+					continue
+				}
+				fetched++
+			}
+			posts = append(posts, manifest.Post{Title: entry.Title, URL: entry.URL, Published: content.Published, HTML: content.HTML})
+
+			// The book is dated by its earliest post, and updates by their latest
+			if !content.Published.IsZero() && (firstPublished.IsZero() || content.Published.Before(firstPublished)) {
+				firstPublished = content.Published
+			}
+			if content.Published.After(lastPublished) {
+				lastPublished = content.Published
+			}
+
+			// Cleanup the HTML - remove inline styles, fix formatting
+			cleanedHTML := htmlProc.CleanHTML(content.HTML, entry.Title)
+
+			// Apply term consistency and errata rules to the text
+			if replacer != nil {
+				cleanedHTML = replacer.Apply(cleanedHTML, entry.Title, entry.URL)
+			}
+
+			// Link the first occurrence of glossary terms to the glossary appendix
+			if glossaryLinker != nil {
+				cleanedHTML = glossaryLinker.Link(cleanedHTML)
+			}
+
+			// Process images in the content
+			processedHTML, err := imgProc.ProcessImages(cleanedHTML, entry.URL, entry.Title)
+			if err != nil {
+				slog.Warn("Error processing images", "error", err)
+				processedHTML = cleanedHTML // Fallback to cleaned HTML without image processing
+			}
+
+			// Extract color illustrations at the top of the post as full-page sections
+			var illustrations []utils.Illustration
+			if cfg.Illustrations != "inline" {
+				processedHTML, illustrations = imgProc.ExtractIllustrations(processedHTML)
+				if firstIllustration.Src == "" {
+					firstIllustration = firstOf(illustrations)
+				}
+			} else if cfg.CoverFromIllustration && firstIllustration.Src == "" {
+				// Inline illustrations stay in the text, they are only looked up for the cover
+				_, found := imgProc.ExtractIllustrations(processedHTML)
+				firstIllustration = firstOf(found)
+			}
+
+			// Check if we're continuing the same chapter or starting a new one
+			if currentChapter != nil && entry.Title == currentChapter.Title {
+				// Continuing the same chapter - append the content
+				slog.Info("Continuing chapter", "title", entry.Title)
+				currentChapter.AppendContent(processedHTML)
+				currentChapter.AddIllustrations(illustrations)
+			} else {
+				// Add the illustrations of the previous chapter before its content
+				if currentChapter != nil {
+					b.AddIllustrations(currentChapter.Illustrations)
+				}
+
+				// If we have content from the previous chapter, add it to the book
+				if currentChapter != nil && currentChapter.HasContent() {
+					// Create chapter HTML with proper styling
+					chapterHTML := htmlProc.ProcessChapterContent(currentChapter.Title, currentChapter.GetContent())
+
+					// Add the chapter to the book
+					if err := b.AddChapter(currentChapter.Title, chapterHTML); err != nil {
+						slog.Warn("Error adding chapter to book", "title", currentChapter.Title, "error", err)
+					}
+				}
 
-	for i, entry := range urlEntries {
-		slog.Info("Processing URL", "index", i+1, "total", len(urlEntries), "title", entry.Title, "url", entry.URL)
+				// Start a new chapter
+				currentChapter = book.NewChapter(entry.Title)
+				currentChapter.AppendContent(processedHTML)
+				currentChapter.AddIllustrations(illustrations)
+				chapterIndex++
+			}
+		}
+
+		// Don't forget to add the last chapter if there is one
+		if currentChapter != nil {
+			b.AddIllustrations(currentChapter.Illustrations)
+		}
+		if currentChapter != nil && currentChapter.HasContent() {
+			// Create chapter HTML with proper styling
+			chapterHTML := htmlProc.ProcessChapterContent(currentChapter.Title, currentChapter.GetContent())
+
+			// Add the chapter to the book
+			if err := b.AddChapter(currentChapter.Title, chapterHTML); err != nil {
+				slog.Warn("Error adding final chapter to book", "title", currentChapter.Title, "error", err)
+			}
+		}
+
+		if volume.Title != "" {
+			b.EndVolume()
+		}
+	}
+
+	// Date the book by its earliest post unless a date was given
+	if cfg.Date.IsZero() && !firstPublished.IsZero() {
+		slog.Info("Using the earliest post date as the publication date", "date", firstPublished.Format(time.DateOnly))
+		b.SetPublicationDate(firstPublished)
+	}
+
+	// Number and date the revision of an updated book
+	if previous != nil {
+		b.Metadata.Revision = previous.Revision + 1
+		b.Metadata.Revised = time.Now().UTC()
+		if cfg.Reproducible {
+			b.Metadata.Revised = lastPublished
+		}
+		slog.Info("Updating book", "revision", b.Metadata.Revision, "new_posts", fetched, "reused_posts", len(posts)-fetched)
+	}
+
+	// Retry the images that failed, now that transient errors may have cleared
+	if replacements := imgProc.RetryFailed(); len(replacements) > 0 {
+		count := b.RewriteSections(func(body string) (string, int) {
+			return processor.ReplaceByID(body, replacements)
+		})
+		slog.Info("Recovered failed images", "images", len(replacements), "references", count)
+	}
 
-		// Download and process the page
-		content, err := s.ExtractContent(entry.URL, i)
+	// Use the first illustration or a generated cover when no cover was downloaded
+	if coverData == nil && cfg.CoverFromIllustration {
+		if coverData = imgProc.ImageData(firstIllustration.Src); coverData != nil {
+			coverSource = "first illustration"
+			slog.Info("Using the first illustration as the cover")
+		}
+	}
+	if coverData == nil {
+		slog.Info("Generating cover image", "theme", coverTheme.Name)
+		coverData, err = cover.Render(cover.Options{
+			Title:  cfg.Title,
+			Volume: cfg.Volume,
+			Author: cfg.Author,
+			Theme:  coverTheme,
+		})
 		if err != nil {
-			slog.Warn("Error processing URL", "url", entry.URL, "error", err)
-			continue
+			slog.Error("Error generating cover image", "error", err)
+			return 1
+		}
+		coverSource = "generated cover"
+	}
+
+	if err := b.SetCover(coverData, coverSource); err != nil {
+		slog.Error("Error adding cover image", "error", err)
+		return 1
+	}
+
+	// Add the glossary appendix after the last chapter
+	if len(glossary) > 0 {
+		slog.Info("Adding glossary chapter", "terms", len(glossary))
+		if err := b.AddGlossaryChapter("Glossary", processor.GlossaryFilename, glossary); err != nil {
+			slog.Warn("Error adding glossary chapter", "error", err)
 		}
+	}
 
-		// Cleanup the HTML - remove inline styles, fix formatting
-		cleanedHTML := htmlProc.CleanHTML(content.HTML, entry.Title)
+	// Add the collected images, downscaling them to fit the size budget if any
+	var imageBudget int64
+	if cfg.MaxSize > 0 {
+		imageBudget = max(1, cfg.MaxSize-b.EstimateSize())
+		slog.Info("Fitting images to the size budget", "max_size", cfg.MaxSize, "image_budget", imageBudget)
+	}
+	if replacements := imgProc.AddToBook(imageBudget); len(replacements) > 0 {
+		b.ReplaceInSections(replacements)
+	}
+
+	// Report how many images were deduplicated and which ones are missing
+	imgProc.LogSummary()
+	imgProc.LogFailures()
+
+	// Report how many replacements each rule made
+	if replacer != nil {
+		replacer.Report()
+	}
 
-		// Process images in the content
-		processedHTML, err := imgProc.ProcessImages(cleanedHTML, entry.URL)
+	// Write the EPUB and KEPUB files
+	if cfg.HasFormat("epub") || cfg.HasFormat("kepub") {
+		epubGen := epub.New(epub.Config{
+			OutputFile:   cfg.OutputFile,
+			TempDir:      cfg.TempDir,
+			Identifier:   identifier,
+			Reproducible: cfg.Reproducible,
+			OmitNCX:      !cfg.NCX,
+			PageList:     cfg.PageList,
+			SplitSize:    int(cfg.SplitSize),
+		})
+		written, err := epubGen.Write(b, epubFormat(cfg))
 		if err != nil {
-			slog.Warn("Error processing images", "error", err)
-			processedHTML = cleanedHTML // Fallback to cleaned HTML without image processing
+			slog.Error("Error writing EPUB", "error", err)
+			return 1
 		}
 
-		// Check if we're continuing the same chapter or starting a new one
-		if currentChapter != nil && entry.Title == currentChapter.Title {
-			// Continuing the same chapter - append the content
-			slog.Info("Continuing chapter", "title", entry.Title)
-			currentChapter.AppendContent(processedHTML)
-		} else {
-			// If we have content from the previous chapter, add it to the EPUB
-			if currentChapter != nil && currentChapter.HasContent() {
-				// Create chapter HTML with proper styling
-				chapterHTML := htmlProc.ProcessChapterContent(currentChapter.Title, currentChapter.GetContent())
-
-				// Add the chapter to the EPUB
-				if err := epubGen.AddChapter(currentChapter.Title, chapterHTML); err != nil {
-					slog.Warn("Error adding chapter to EPUB", "title", currentChapter.Title, "error", err)
+		// Check the written files so broken books are noticed before a reader refuses them
+		for _, filename := range written {
+			report := validate.File(filename)
+			for _, m := range report.Messages {
+				if m.Severity == validate.Warning {
+					slog.Warn("EPUB validation", "message", m.String())
+				} else {
+					slog.Error("EPUB validation", "message", m.String())
 				}
 			}
+			slog.Info("EPUB validation finished", "file", filename, "summary", report.Summary())
+		}
+	}
+
+	// Write the other formats from the same chapters
+	if cfg.HasFormat("html") {
+		filename := cfg.OutputName(".html")
+		if err := export.WriteHTML(b, filename); err != nil {
+			slog.Error("Error writing HTML", "error", err)
+			return 1
+		}
+		slog.Info("Successfully created HTML file", "file", filename)
+	}
+	if cfg.HasFormat("markdown") {
+		dir := cfg.OutputName("-markdown")
+		if err := export.WriteMarkdown(b, dir); err != nil {
+			slog.Error("Error writing Markdown", "error", err)
+			return 1
+		}
+		slog.Info("Successfully created Markdown folder", "dir", dir)
+	}
+	if cfg.HasFormat("text") {
+		filename := cfg.OutputName(".txt")
+		if err := export.WriteText(b, filename); err != nil {
+			slog.Error("Error writing text", "error", err)
+			return 1
+		}
+		slog.Info("Successfully created text file", "file", filename)
+	}
+	if cfg.HasFormat("fb2") {
+		filename := cfg.OutputName(".fb2")
+		if err := export.WriteFB2(b, filename, identifier); err != nil {
+			slog.Error("Error writing FB2", "error", err)
+			return 1
+		}
+		slog.Info("Successfully created FB2 file", "file", filename)
+	}
 
-			// Start a new chapter
-			currentChapter = epub.NewChapter(entry.Title)
-			currentChapter.AppendContent(processedHTML)
-			chapterIndex++
+	// Record the build so the book can be updated with new chapters
+	if cfg.Manifest {
+		m := &manifest.Manifest{
+			Revision:   max(1, b.Metadata.Revision),
+			Modified:   time.Now().UTC(),
+			Identifier: identifier,
+			Args:       cfg.Args,
+			Posts:      posts,
+			Images:     imgProc.EmbeddedImages(),
+		}
+		if m.Dir, err = os.Getwd(); err != nil {
+			slog.Error("Error getting the working directory", "error", err)
+			return 1
+		}
+		if cfg.CoverURL != "" && coverSource == cfg.CoverURL {
+			m.CoverURL, m.Cover = cfg.CoverURL, b.Cover.Name
 		}
+		filename := cfg.OutputName(manifestSuffix)
+		if err := m.Write(filename); err != nil {
+			slog.Error("Error writing build manifest", "error", err)
+			return 1
+		}
+		slog.Info("Successfully created build manifest", "file", filename, "revision", m.Revision)
 	}
 
-	// Don't forget to add the last chapter if there is one
-	if currentChapter != nil && currentChapter.HasContent() {
-		// Create chapter HTML with proper styling
-		chapterHTML := htmlProc.ProcessChapterContent(currentChapter.Title, currentChapter.GetContent())
+	return 0
+}
 
-		// Add the chapter to the EPUB
-		if err := epubGen.AddChapter(currentChapter.Title, chapterHTML); err != nil {
-			slog.Warn("Error adding final chapter to EPUB", "title", currentChapter.Title, "error", err)
+// firstOf returns the first illustration of a list, or an empty one
+func firstOf(illustrations []utils.Illustration) utils.Illustration {
+	if len(illustrations) == 0 {
+		return utils.Illustration{}
+	}
+	return illustrations[0]
+}
+
+// upToDate returns true if a previous build already has every post of the URL lists
+func upToDate(previous *manifest.Manifest, urlEntries []utils.URLEntry) bool {
+	if len(urlEntries) != len(previous.Posts) {
+		return false
+	}
+	for _, entry := range urlEntries {
+		if previous.Find(entry.Title, entry.URL) == nil {
+			return false
 		}
 	}
+	return true
+}
 
-	// Write the EPUB file
-	if err := epubGen.Write(); err != nil {
-		slog.Error("Error writing EPUB", "error", err)
-		return 1
+// epubFormat returns the EPUB variants to write for the requested formats
+func epubFormat(cfg *config.Config) epub.OutputFormat {
+	var format epub.OutputFormat
+	if cfg.HasFormat("epub") {
+		format |= epub.FormatEPUB
+	}
+	if cfg.HasFormat("kepub") {
+		format |= epub.FormatKEPUB
 	}
+	return format
+}
 
-	slog.Info("Successfully created EPUB", "file", cfg.OutputFile)
-	return 0
+// bookMetadata builds the publication metadata from the configuration
+func bookMetadata(cfg *config.Config) book.Metadata {
+	metadata := book.Metadata{
+		Language:    cfg.Language,
+		Description: cfg.Description,
+		Publisher:   cfg.Publisher,
+		Date:        cfg.Date,
+		Subjects:    cfg.Subjects,
+		Series:      cfg.Series,
+	}
+	for _, name := range cfg.Illustrators {
+		metadata.Contributors = append(metadata.Contributors, book.Contributor{Name: name, Role: book.RoleIllustrator})
+	}
+	for _, name := range cfg.Translators {
+		metadata.Contributors = append(metadata.Contributors, book.Contributor{Name: name, Role: book.RoleTranslator})
+	}
+
+	// The volume number doubles as the series index when it is numeric
+	if cfg.Series != "" && cfg.Volume != "" {
+		if book.ValidSeriesIndex(cfg.Volume) {
+			metadata.SeriesIndex = cfg.Volume
+		} else {
+			slog.Warn("Volume is not a number, not using it as the series index", "volume", cfg.Volume)
+		}
+	}
+
+	return metadata
+}
+
+// Validate checks existing EPUB files and prints an epubcheck-like report for each
+func Validate(files []string) int {
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: seireitranslations-epub validate FILE.epub...")
+		return 2
+	}
+
+	exitCode := 0
+	for _, file := range files {
+		fmt.Printf("Validating %s\n", file)
+		report := validate.File(file)
+		report.Write(os.Stdout)
+		if report.HasErrors() {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+// volumeCover embeds the cover of an omnibus volume, shown on its title page
+func volumeCover(imgProc *processor.ImageProcessor, volume utils.VolumeEntry) utils.Illustration {
+	if volume.CoverURL == "" {
+		return utils.Illustration{}
+	}
+	content := fmt.Sprintf(`<p><img src="%s" alt="%s"/></p>`, html.EscapeString(volume.CoverURL), html.EscapeString(volume.Title+" cover"))
+	processedHTML, err := imgProc.ProcessImages(content, volume.CoverURL, volume.Title)
+	if err != nil {
+		slog.Warn("Error processing volume cover", "title", volume.Title, "error", err)
+		return utils.Illustration{}
+	}
+	if _, illustrations := imgProc.ExtractIllustrations(processedHTML); len(illustrations) > 0 {
+		return illustrations[0]
+	}
+	slog.Warn("Volume cover could not be embedded", "title", volume.Title, "cover", volume.CoverURL)
+	return utils.Illustration{}
 }
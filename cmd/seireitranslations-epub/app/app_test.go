@@ -0,0 +1,180 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ynsta/seireitranslations-epub/internal/config"
+	"github.com/ynsta/seireitranslations-epub/internal/manifest"
+)
+
+// testPost returns a Blogger-like page holding a post
+func testPost(title string, body string) string {
+	var paragraphs strings.Builder
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(&paragraphs, "<p>%s goes on with a long paragraph, so that the content has enough text to be kept, part %d.</p>", title, i)
+	}
+	return `<html><head><meta property="article:published_time" content="2024-03-01T10:00:00+09:00"></head><body>` +
+		`<div class="post-body"><h4>` + title + `</h4>` + body + paragraphs.String() + `</div></body></html>`
+}
+
+// testServer serves posts and images, counting the requests for each path
+func testServer(t *testing.T) (*httptest.Server, func(path string) int) {
+	t.Helper()
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/image.png":
+			img := image.NewRGBA(image.Rect(0, 0, 30, 40))
+			img.Set(1, 1, color.RGBA{200, 10, 10, 255})
+			if err := png.Encode(w, img); err != nil {
+				t.Error(err)
+			}
+		case "/one":
+			fmt.Fprint(w, testPost("Chapter One", `<p>Before the image.</p><p><img src="`+srv.URL+`/image.png"></p>`))
+		case "/two":
+			fmt.Fprint(w, testPost("Chapter Two", ""))
+		case "/three":
+			fmt.Fprint(w, testPost("Chapter Three", ""))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return hits[path]
+	}
+}
+
+func TestUpdateRelativePaths(t *testing.T) {
+	srv, hits := testServer(t)
+
+	// Build the book from its folder, with relative paths
+	dir := t.TempDir()
+	urls := fmt.Sprintf("Chapter One::%s/one\nChapter Two::%s/two\n", srv.URL, srv.URL)
+	if err := os.WriteFile(filepath.Join(dir, "urls.txt"), []byte(urls), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var cover bytes.Buffer
+	if err := png.Encode(&cover, image.NewRGBA(image.Rect(0, 0, 60, 90))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cover.png"), cover.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	cfg, err := config.ParseArgs([]string{"--title", "Test Book", "--author", "Someone",
+		"--cover", "cover.png", "--output", "out.epub", "--urls", "urls.txt", "--manifest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := build(cfg, nil, nil); code != 0 {
+		t.Fatalf("build exited with status %d", code)
+	}
+
+	// Update from another folder
+	other := t.TempDir()
+	t.Chdir(other)
+	book := filepath.Join(dir, "out.epub")
+	readRevision := func() int {
+		t.Helper()
+		m, err := manifest.Read(filepath.Join(dir, "out.manifest.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.Revision
+	}
+
+	// Nothing changed: the book is up to date and no post is downloaded again
+	if code := Update([]string{book}); code != 0 {
+		t.Fatalf("update exited with status %d", code)
+	}
+	if revision := readRevision(); revision != 1 {
+		t.Errorf("revision = %d after an update without changes, want 1", revision)
+	}
+	if hits("/one") != 1 || hits("/two") != 1 {
+		t.Errorf("posts downloaded again: /one %d times, /two %d times", hits("/one"), hits("/two"))
+	}
+
+	// A new post: only that post is downloaded, the images come from the book
+	urls += fmt.Sprintf("Chapter Three::%s/three\n", srv.URL)
+	if err := os.WriteFile(filepath.Join(dir, "urls.txt"), []byte(urls), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := Update([]string{book}); code != 0 {
+		t.Fatalf("update exited with status %d", code)
+	}
+	if revision := readRevision(); revision != 2 {
+		t.Errorf("revision = %d after adding a post, want 2", revision)
+	}
+	if hits("/one") != 1 || hits("/two") != 1 || hits("/three") != 1 || hits("/image.png") != 1 {
+		t.Errorf("unexpected downloads: /one %d, /two %d, /three %d, /image.png %d",
+			hits("/one"), hits("/two"), hits("/three"), hits("/image.png"))
+	}
+
+	// Nothing is written to the current folder
+	entries, err := os.ReadDir(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) > 0 {
+		t.Errorf("update wrote %s to the current folder", entries[0].Name())
+	}
+}
+
+func TestValidateExitStatus(t *testing.T) {
+	srv, _ := testServer(t)
+	dir := t.TempDir()
+	urls := fmt.Sprintf("Chapter One::%s/one\nChapter Two::%s/two\n", srv.URL, srv.URL)
+	if err := os.WriteFile(filepath.Join(dir, "urls.txt"), []byte(urls), 0644); err != nil {
+		t.Fatal(err)
+	}
+	book := filepath.Join(dir, "out.epub")
+	cfg, err := config.ParseArgs([]string{"--title", "Test Book", "--author", "Someone",
+		"--output", book, "--urls", filepath.Join(dir, "urls.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := build(cfg, nil, nil); code != 0 {
+		t.Fatalf("build exited with status %d", code)
+	}
+	broken := filepath.Join(dir, "broken.epub")
+	if err := os.WriteFile(broken, []byte("not a zip archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		files []string
+		want  int
+	}{
+		{"built book", []string{book}, 0},
+		{"broken file", []string{broken}, 1},
+		{"one broken file among valid ones", []string{book, broken, book}, 1},
+		{"no file", nil, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := Validate(test.files); code != test.want {
+				t.Errorf("Validate exited with status %d, want %d", code, test.want)
+			}
+		})
+	}
+}
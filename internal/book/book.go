@@ -0,0 +1,417 @@
+package book
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"html"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ynsta/seireitranslations-epub/internal/imaging"
+	"github.com/ynsta/seireitranslations-epub/internal/logger"
+	"github.com/ynsta/seireitranslations-epub/pkg/utils"
+)
+
+// Structural semantics of sections, used for landmarks
+const (
+	TypeCredits    = "credits"
+	TypeBodymatter = "bodymatter"
+	TypeBackmatter = "backmatter"
+)
+
+// ImageFolder is the folder of images, relative to the folder of the sections
+// that reference them as ../images/<name>
+const ImageFolder = "images"
+
+// Book holds the assembled content of a volume, independent of the output format
+//
+// Sections are XHTML fragments referencing images through ImagePath, the same
+// layout as in the EPUB; writers of other formats rewrite the references.
+type Book struct {
+	Title    string
+	Author   string
+	Metadata Metadata
+
+	// Cover and Stylesheet are empty until they are set
+	Cover      Image
+	Stylesheet []byte
+
+	// Illustrations are the gathered full-page illustrations placed right after the cover
+	Illustrations []Section
+	Sections      []Section
+	Images        []Image
+
+	tempDir             string
+	debug               bool
+	gatherIllustrations bool
+	mediaBytes          int64
+
+	// level is the level of the sections being added, 1 inside a volume of an omnibus
+	level int
+
+	// volumeStart is the position of the first section after the start of the
+	// current volume, and gatherAt the position of its next gathered illustration
+	volumeStart int
+	gatherAt    int
+}
+
+// Section is a titled part of the book, such as a chapter or an illustration page
+type Section struct {
+	Title string
+
+	// Filename is the name of the section in formats split into files, if it
+	// must be known in advance to link to it
+	Filename string
+
+	// Type is the structural type of the section
+	Type string
+	Body string
+
+	// Level is the depth of the section in the table of contents, 1 for the
+	// sections nested in a volume of an omnibus
+	Level int
+}
+
+// Image is an image file referenced by the sections
+type Image struct {
+	Name string
+	Path string
+}
+
+// Config holds the configuration of a book
+type Config struct {
+	Title    string
+	Author   string
+	TempDir  string
+	Debug    bool
+	Metadata Metadata
+
+	// GatherIllustrations places all full-page illustrations in a single
+	// "Color Illustrations" section right after the cover
+	GatherIllustrations bool
+}
+
+// New creates an empty book
+func New(config Config) *Book {
+	return &Book{
+		Title:               config.Title,
+		Author:              config.Author,
+		Metadata:            config.Metadata,
+		tempDir:             config.TempDir,
+		debug:               config.Debug,
+		gatherIllustrations: config.GatherIllustrations,
+	}
+}
+
+// ImagePath returns the path sections use to reference an image
+func ImagePath(name string) string {
+	return path.Join("..", ImageFolder, name)
+}
+
+// SetCover sets the cover image; the source is only used in error messages
+func (b *Book) SetCover(coverData []byte, coverURL string) error {
+	// Determine the file extension from the image content, converting formats
+	// that EPUB readers don't support
+	coverData, format, err := imaging.Normalize(coverData)
+	if err != nil {
+		return fmt.Errorf("error converting cover image from %s: %v", coverURL, err)
+	}
+	coverFilename := "cover" + format.Extension
+
+	// Save the cover image to a temporary file
+	tempCoverPath := filepath.Join(b.tempDir, coverFilename)
+	if err := os.WriteFile(tempCoverPath, coverData, 0600); err != nil {
+		return fmt.Errorf("error saving cover image: %v", err)
+	}
+
+	b.Cover = Image{Name: coverFilename, Path: tempCoverPath}
+	b.mediaBytes += int64(len(coverData))
+
+	return nil
+}
+
+// SetStylesheet sets the CSS stylesheet applied to every section
+func (b *Book) SetStylesheet(cssData []byte) {
+	b.Stylesheet = cssData
+	b.mediaBytes += int64(len(cssData))
+}
+
+// SetPublicationDate sets the publication date of the book
+func (b *Book) SetPublicationDate(date time.Time) {
+	b.Metadata.Date = date
+}
+
+// AddImage adds an image file to the book and returns the path sections use to reference it
+func (b *Book) AddImage(sourcePath string, name string) string {
+	b.Images = append(b.Images, Image{Name: name, Path: sourcePath})
+	return ImagePath(name)
+}
+
+// AddAttributionChapter adds a chapter with attribution information and support links
+func (b *Book) AddAttributionChapter(title string, urlEntries []utils.URLEntry) error {
+	// Create HTML content for the attribution chapter
+	content := `<div class="attribution" epub:type="credits">
+<h1>Attribution</h1>
+<p>This e-book contains content translated by <strong>SeireiTranslations</strong>.</p>
+
+<h2>Support the Translators</h2>
+<p>If you enjoy this translation, please consider supporting the translators to help them continue their work:</p>
+<ul>
+<li><a href="https://ko-fi.com/seireitranslations">Support on Ko-Fi</a></li>
+<li><a href="https://www.patreon.com/seireitl">Support on Patreon</a></li>
+</ul>
+
+<h2>Original Content Sources</h2>
+<p>The content in this e-book was sourced from the following links:</p>
+<ul>
+`
+
+	// Add each URL as a list item
+	for _, entry := range urlEntries {
+		content += fmt.Sprintf("<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(entry.URL), html.EscapeString(entry.Title))
+	}
+
+	// Close the HTML tags
+	content += `</ul>
+</div>`
+
+	// Add the attribution chapter to the book
+	b.addSection(title, "", TypeCredits, content)
+
+	return nil
+}
+
+// AddGlossaryChapter adds an appendix chapter defining the terms of a glossary
+func (b *Book) AddGlossaryChapter(title string, filename string, entries []utils.GlossaryEntry) error {
+	// Create HTML content for the glossary chapter
+	content := fmt.Sprintf(`<section class="glossary" epub:type="appendix glossary">
+<h1>%s</h1>
+<dl>
+`, html.EscapeString(title))
+
+	// Add each term with its aliases and definition
+	anchors := utils.GlossaryAnchors(entries)
+	for i, entry := range entries {
+		term := html.EscapeString(entry.Term)
+		if len(entry.Aliases) > 0 {
+			term += fmt.Sprintf(" <span class=\"aliases\">(%s)</span>", html.EscapeString(strings.Join(entry.Aliases, ", ")))
+		}
+		content += fmt.Sprintf("<dt id=\"%s\" epub:type=\"glossterm\">%s</dt>\n", anchors[i], term)
+		content += fmt.Sprintf("<dd epub:type=\"glossdef\">%s</dd>\n", html.EscapeString(entry.Definition))
+	}
+
+	// Close the HTML tags
+	content += `</dl>
+</section>`
+
+	// Add the glossary chapter to the book
+	b.addSection(title, filename, TypeBackmatter, content)
+
+	return nil
+}
+
+// AddChapter adds a chapter to the book
+func (b *Book) AddChapter(title string, content string) error {
+	// Debug logging when debug mode is enabled
+	if b.debug {
+		if logger.Debug {
+			slog.Debug("AddChapter called", "title", title, "content_length", len(content))
+		}
+
+		// Check if content is empty or very short
+		if len(content) < 100 {
+			slog.Warn("Content is very short or empty in AddChapter", "title", title, "content", content)
+		} else if logger.Debug {
+			previewContent := content
+			if len(content) > 100 {
+				previewContent = content[:100]
+			}
+			slog.Debug("Content in AddChapter preview", "title", title, "preview", previewContent)
+		}
+
+		// Save debug file in temp directory
+		safeTitle := sanitizeFilename(title)
+		debugFilePath := filepath.Join(b.tempDir, fmt.Sprintf("debug_%s_epub.html", safeTitle))
+		if err := os.WriteFile(debugFilePath, []byte(content), 0600); err != nil {
+			slog.Error("Failed to save debug file", "error", err)
+		} else if logger.Debug {
+			slog.Debug("Saved content to debug file", "title", title, "path", debugFilePath)
+		}
+	}
+
+	// Add the chapter to the book
+	b.addSection(title, "", TypeBodymatter, content)
+
+	return nil
+}
+
+// StartVolume starts a volume of an omnibus, with the volume cover if it has one
+//
+// The sections added until EndVolume are nested under the volume in the
+// table of contents.
+func (b *Book) StartVolume(title string, cover utils.Illustration) {
+	b.level = 0
+	content := fmt.Sprintf(`<section class="volume" epub:type="part">
+<h1>%s</h1>
+`, html.EscapeString(title))
+	if cover.Src != "" {
+		content += fmt.Sprintf(`<p class="volume-cover"><img src="%s" alt="%s"/></p>
+`, html.EscapeString(cover.Src), html.EscapeString(cover.Alt))
+	}
+	content += `</section>`
+
+	b.addSection(title, "", TypeBodymatter, content)
+	b.level = 1
+	b.volumeStart = len(b.Sections)
+	b.gatherAt = b.volumeStart
+}
+
+// EndVolume ends the current volume of an omnibus
+func (b *Book) EndVolume() {
+	b.level = 0
+}
+
+// AddIllustrations adds full-page illustration sections to the book
+//
+// The illustrations are placed at the current position in the book, or gathered
+// into a "Color Illustrations" section right after the cover, or right after
+// the start of the volume in an omnibus.
+func (b *Book) AddIllustrations(illustrations []utils.Illustration) {
+	for _, illustration := range illustrations {
+		section := Section{Body: illustrationBody(illustration), Level: b.level}
+		switch {
+		case b.gatherIllustrations && b.level > 0:
+			if b.gatherAt == b.volumeStart {
+				section.Title = "Color Illustrations"
+			}
+			b.Sections = append(b.Sections[:b.gatherAt], append([]Section{section}, b.Sections[b.gatherAt:]...)...)
+			b.gatherAt++
+		case b.gatherIllustrations:
+			if len(b.Illustrations) == 0 {
+				section.Title = "Color Illustrations"
+			}
+			b.Illustrations = append(b.Illustrations, section)
+		default:
+			section.Type = TypeBodymatter
+			b.Sections = append(b.Sections, section)
+		}
+	}
+}
+
+// illustrationBody creates the body of a full-page illustration section
+func illustrationBody(illustration utils.Illustration) string {
+	src := html.EscapeString(illustration.Src)
+	alt := html.EscapeString(illustration.Alt)
+	caption := html.EscapeString(illustration.Caption)
+
+	// Without known dimensions the image can't be wrapped in a scaled SVG
+	if illustration.Width <= 0 || illustration.Height <= 0 {
+		titleAttr := ""
+		if caption != "" {
+			titleAttr = fmt.Sprintf(` title="%s"`, caption)
+		}
+		return fmt.Sprintf(`<div class="illustration" epub:type="illustration">
+<img src="%s" alt="%s"%s/>
+</div>`, src, alt, titleAttr)
+	}
+
+	// The figure caption, else the alt text, describes the page
+	title := ""
+	if caption != "" {
+		title = fmt.Sprintf("<title>%s</title>\n", caption)
+	} else if alt != "" {
+		title = fmt.Sprintf("<title>%s</title>\n", alt)
+	}
+
+	return fmt.Sprintf(`<div class="illustration" epub:type="illustration">
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" version="1.1" width="100%%" height="100%%" viewBox="0 0 %d %d" preserveAspectRatio="xMidYMid meet">
+%s<image width="%d" height="%d" xlink:href="%s"/>
+</svg>
+</div>`, illustration.Width, illustration.Height, title, illustration.Width, illustration.Height, src)
+}
+
+// addSection appends a section to the book
+func (b *Book) addSection(title string, filename string, sectionType string, body string) {
+	b.Sections = append(b.Sections, Section{
+		Title:    title,
+		Filename: filename,
+		Type:     sectionType,
+		Body:     body,
+		Level:    b.level,
+	})
+}
+
+// ReadingOrder returns all the sections, gathered illustrations first
+func (b *Book) ReadingOrder() []Section {
+	return append(append([]Section(nil), b.Illustrations...), b.Sections...)
+}
+
+// ReplaceInSections replaces markup in the sections and returns the number of replacements
+func (b *Book) ReplaceInSections(replacements map[string]string) int {
+	count := 0
+	for _, sections := range [][]Section{b.Illustrations, b.Sections} {
+		for i := range sections {
+			for old, repl := range replacements {
+				if n := strings.Count(sections[i].Body, old); n > 0 {
+					sections[i].Body = strings.ReplaceAll(sections[i].Body, old, repl)
+					count += n
+				}
+			}
+		}
+	}
+	return count
+}
+
+// RewriteSections rewrites the body of every section and returns the total count reported by rewrite
+func (b *Book) RewriteSections(rewrite func(body string) (string, int)) int {
+	count := 0
+	for _, sections := range [][]Section{b.Illustrations, b.Sections} {
+		for i := range sections {
+			var n int
+			sections[i].Body, n = rewrite(sections[i].Body)
+			count += n
+		}
+	}
+	return count
+}
+
+// EstimateSize estimates the size of the EPUB without its chapter images
+//
+// Sections are compressed like in the final archive; the cover and stylesheet
+// are counted as is, and a fixed overhead accounts for the package document,
+// navigation files and section templates.
+func (b *Book) EstimateSize() int64 {
+	size := b.mediaBytes + 8192
+	for _, section := range b.ReadingOrder() {
+		var buf bytes.Buffer
+		w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			size += int64(len(section.Body))
+			continue
+		}
+		_, _ = w.Write([]byte(section.Body))
+		_ = w.Close()
+		size += int64(buf.Len()) + 1024
+	}
+	return size
+}
+
+// sanitizeFilename creates a safe filename from a title by removing special characters
+func sanitizeFilename(title string) string {
+	// Replace special characters with underscores
+	re := regexp.MustCompile(`[^a-zA-Z0-9_]`)
+	safe := re.ReplaceAllString(title, "_")
+
+	// Truncate if too long (filesystem limits)
+	if len(safe) > 50 {
+		safe = safe[:50]
+	}
+
+	return safe
+}
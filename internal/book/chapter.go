@@ -0,0 +1,85 @@
+package book
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/ynsta/seireitranslations-epub/internal/logger"
+	"github.com/ynsta/seireitranslations-epub/pkg/utils"
+)
+
+// Chapter accumulates the content of the posts making up a chapter
+type Chapter struct {
+	Title         string
+	Content       strings.Builder
+	Illustrations []utils.Illustration
+	Debug         bool
+}
+
+// NewChapter creates a new Chapter
+func NewChapter(title string) *Chapter {
+	return &Chapter{
+		Title: title,
+	}
+}
+
+// SetDebug sets the debug flag for this chapter
+func (c *Chapter) SetDebug(debug bool) {
+	c.Debug = debug
+}
+
+// AppendContent appends content to the chapter
+func (c *Chapter) AppendContent(content string) {
+	// Debug logging if debug is enabled
+	if c.Debug {
+		if logger.Debug {
+			slog.Debug("AppendContent called",
+				"chapter", c.Title,
+				"content_length", len(content),
+				"current_total", c.Content.Len())
+		}
+
+		// Check if content is empty or very short
+		if len(content) < 100 {
+			slog.Warn("Content being appended is very short", "chapter", c.Title, "content", content)
+		} else if logger.Debug {
+			previewContent := content
+			if len(content) > 100 {
+				previewContent = content[:100]
+			}
+			slog.Debug("Content being appended preview", "chapter", c.Title, "preview", previewContent)
+		}
+	}
+
+	c.Content.WriteString(content)
+
+	// Debug logging after append
+	if c.Debug && logger.Debug {
+		slog.Debug("Content appended", "chapter", c.Title, "total_length", c.Content.Len())
+	}
+}
+
+// AddIllustrations records full-page illustrations extracted from the chapter
+func (c *Chapter) AddIllustrations(illustrations []utils.Illustration) {
+	c.Illustrations = append(c.Illustrations, illustrations...)
+}
+
+// GetContent returns the chapter content
+func (c *Chapter) GetContent() string {
+	return c.Content.String()
+}
+
+// HasContent returns true if the chapter has content
+func (c *Chapter) HasContent() bool {
+	hasContent := c.Content.Len() > 0
+
+	// Debug logging if debug is enabled
+	if c.Debug && logger.Debug {
+		slog.Debug("HasContent check",
+			"chapter", c.Title,
+			"content_length", c.Content.Len(),
+			"has_content", hasContent)
+	}
+
+	return hasContent
+}
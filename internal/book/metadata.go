@@ -0,0 +1,54 @@
+package book
+
+import (
+	"strconv"
+	"time"
+)
+
+// MARC relator codes of the supported contributor roles
+const (
+	RoleAuthor      = "aut"
+	RoleIllustrator = "ill"
+	RoleTranslator  = "trl"
+)
+
+// Contributor is a person or group credited in the book metadata
+type Contributor struct {
+	Name string
+	Role string
+}
+
+// Metadata holds the publication metadata of a book
+type Metadata struct {
+	Language     string
+	Description  string
+	Publisher    string
+	Date         time.Time
+	Contributors []Contributor
+	Subjects     []string
+
+	// Series and SeriesIndex place the book in a series of volumes
+	Series      string
+	SeriesIndex string
+
+	// Revision and Revised number and date the updates of a book, zero before the first update
+	Revision int
+	Revised  time.Time
+}
+
+// ContributorsWithRole returns the names of the contributors with the given role
+func (m Metadata) ContributorsWithRole(role string) []string {
+	var names []string
+	for _, c := range m.Contributors {
+		if c.Role == role {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+// ValidSeriesIndex checks whether a volume number can be used as a series index
+func ValidSeriesIndex(index string) bool {
+	_, err := strconv.ParseFloat(index, 64)
+	return err == nil
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ynsta/seireitranslations-epub/internal/logger"
@@ -12,33 +14,167 @@ import (
 
 // Config holds the application configuration
 type Config struct {
-	Title       string
-	Author      string
-	CoverURL    string
-	OutputFile  string
-	URLListFile string
-	Debug       bool
-	TempDir     string
+	Title                 string
+	Author                string
+	CoverURL              string
+	OutputFile            string
+	URLListFile           string
+	OmnibusFile           string
+	RulesFile             string
+	GlossaryFile          string
+	GlossaryLinks         bool
+	ImageProfile          string
+	ImageSize             int
+	MaxSize               int64
+	Illustrations         string
+	Volume                string
+	CoverTheme            string
+	Language              string
+	Description           string
+	Publisher             string
+	Illustrators          []string
+	Translators           []string
+	Subjects              []string
+	Series                string
+	Date                  time.Time
+	Reproducible          bool
+	Formats               []string
+	NCX                   bool
+	PageList              bool
+	SplitSize             int64
+	AltText               string
+	CoverFromIllustration bool
+	Manifest              bool
+	Debug                 bool
+	TempDir               string
+
+	// Args holds the command-line arguments the configuration was parsed from
+	Args []string
 }
 
 // ParseCommandLine parses command-line arguments and returns a Config
 func ParseCommandLine() (*Config, error) {
-	cfg := &Config{}
+	return ParseArgs(os.Args[1:])
+}
 
-	// Define command-line flags
-	flag.StringVar(&cfg.Title, "title", "", "EPUB title (required)")
-	flag.StringVar(&cfg.Author, "author", "", "Author name (required)")
-	flag.StringVar(&cfg.CoverURL, "cover", "", "Cover image URL (required)")
-	flag.StringVar(&cfg.OutputFile, "output", "", "Output EPUB filename (required)")
-	flag.StringVar(&cfg.URLListFile, "urls", "", "File containing list of URLs to scrape (required)")
-	flag.BoolVar(&cfg.Debug, "debug", false, "Enable debug mode: store temp files in current directory with .tmp suffix and skip cleanup")
-	flag.Parse()
+// ParseArgs parses the given arguments and returns a Config
+func ParseArgs(args []string) (*Config, error) {
+	cfg := &Config{Args: args}
+	var maxSize, splitSize, subjects, date, formats string
+
+	// Define command-line flags, on a new set so the arguments can be parsed more than once
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flags.StringVar(&cfg.Title, "title", "", "EPUB title (required)")
+	flags.StringVar(&cfg.Author, "author", "", "Author name (required)")
+	flags.StringVar(&cfg.CoverURL, "cover", "", "Cover image URL, local path or data: URL (optional, a cover is generated if missing)")
+	flags.StringVar(&cfg.OutputFile, "output", "", "Output EPUB filename (required)")
+	flags.StringVar(&formats, "format", "epub", "Comma-separated output formats: epub, kepub (Kobo, written as .kepub.epub), html, markdown, text or fb2; both is epub,kepub")
+	flags.StringVar(&cfg.URLListFile, "urls", "", "File containing list of URLs to scrape (required unless --omnibus is given)")
+	flags.StringVar(&cfg.OmnibusFile, "omnibus", "", "File listing the volumes merged into one omnibus book, one 'Title::URL list file[::Cover URL]' per line")
+	flags.StringVar(&cfg.RulesFile, "rules", "", "File containing find-and-replace rules applied to chapter text (optional)")
+	flags.StringVar(&cfg.GlossaryFile, "glossary", "", "File containing glossary terms added as an appendix chapter (optional)")
+	flags.BoolVar(&cfg.GlossaryLinks, "glossary-links", false, "Link the first occurrence of each glossary term to its definition")
+	flags.StringVar(&cfg.ImageProfile, "image-profile", "original", "Image profile: original, tablet or e-ink")
+	flags.IntVar(&cfg.ImageSize, "image-size", 0, "Image size in pixels requested from Blogger (0 for the original upload)")
+	flags.StringVar(&maxSize, "max-size", "", "Maximum EPUB size, e.g. 20MB; the largest images are downscaled to fit (optional)")
+	flags.StringVar(&cfg.Illustrations, "illustrations", "inline", "Illustration placement: inline, pages (full-page sections) or gather (full-page sections after the cover)")
+	flags.StringVar(&cfg.AltText, "alt-text", "caption", "Alt text for images without one: caption (figure caption, else chapter title and index), title (chapter title and index) or none")
+	flags.StringVar(&cfg.Volume, "volume", "", "Volume number, shown on generated covers and used as the series index (optional)")
+	flags.StringVar(&cfg.Series, "series", "", "Series name written to the EPUB and Calibre metadata (optional)")
+	flags.StringVar(&cfg.Language, "language", "en", "Language of the book as a BCP 47 tag")
+	flags.StringVar(&cfg.Description, "description", "", "Book description (optional)")
+	flags.StringVar(&cfg.Publisher, "publisher", "", "Publisher name (optional)")
+	flags.Var((*stringList)(&cfg.Illustrators), "illustrator", "Illustrator name, can be repeated (optional)")
+	flags.Var((*stringList)(&cfg.Translators), "translator", "Translator name, can be repeated (optional)")
+	flags.StringVar(&subjects, "subjects", "", "Comma-separated subjects or tags (optional)")
+	flags.StringVar(&date, "date", "", "Publication date as YYYY-MM-DD (optional, defaults to the earliest post date)")
+	flags.StringVar(&cfg.CoverTheme, "cover-theme", "midnight", "Theme of generated covers: midnight, paper or sakura")
+	flags.BoolVar(&cfg.CoverFromIllustration, "cover-from-illustration", false, "Use the first illustration of the volume as the cover when no cover URL is given or it fails")
+	flags.BoolVar(&cfg.NCX, "ncx", true, "Include an EPUB 2 NCX table of contents for older readers")
+	flags.StringVar(&splitSize, "split-size", "0", "Size above which chapters are split over several XHTML files, e.g. 256KB; 0 to never split")
+	flags.BoolVar(&cfg.PageList, "page-list", false, "Add synthetic page numbers to the navigation document")
+	flags.BoolVar(&cfg.Reproducible, "reproducible", false, "Produce byte-identical EPUBs from identical inputs: stable identifier, fixed dates and archive order")
+	flags.BoolVar(&cfg.Manifest, "manifest", false, "Write a build manifest next to the output, used by the update command; posts already in the book are only downloaded again with update --refresh")
+	flags.BoolVar(&cfg.Debug, "debug", false, "Enable debug mode: store temp files in current directory with .tmp suffix and skip cleanup")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
 
 	// Validate required parameters
-	if cfg.Title == "" || cfg.Author == "" || cfg.CoverURL == "" || cfg.OutputFile == "" || cfg.URLListFile == "" {
-		flag.Usage()
+	if cfg.Title == "" || cfg.Author == "" || cfg.OutputFile == "" || (cfg.URLListFile == "" && cfg.OmnibusFile == "") {
+		flags.Usage()
 		return nil, fmt.Errorf("missing required parameters")
 	}
+	if cfg.URLListFile != "" && cfg.OmnibusFile != "" {
+		return nil, fmt.Errorf("--urls and --omnibus can't be used together")
+	}
+
+	// Validate the illustration placement
+	switch cfg.Illustrations {
+	case "inline", "pages", "gather":
+	default:
+		return nil, fmt.Errorf("invalid illustrations mode %q (expected inline, pages or gather)", cfg.Illustrations)
+	}
+
+	// Split and validate the output formats
+	for _, format := range strings.Split(formats, ",") {
+		switch format = strings.TrimSpace(format); format {
+		case "":
+		case "both":
+			cfg.Formats = append(cfg.Formats, "epub", "kepub")
+		case "epub", "kepub", "html", "markdown", "text", "fb2":
+			cfg.Formats = append(cfg.Formats, format)
+		default:
+			return nil, fmt.Errorf("invalid format %q (expected epub, kepub, html, markdown, text, fb2 or both)", format)
+		}
+	}
+	if len(cfg.Formats) == 0 {
+		cfg.Formats = []string{"epub"}
+	}
+	if cfg.HasFormat("epub") && cfg.HasFormat("kepub") && strings.HasSuffix(strings.ToLower(cfg.OutputFile), ".kepub.epub") {
+		return nil, fmt.Errorf("output %q would be used by both the EPUB and the KEPUB, use a name ending in .epub", cfg.OutputFile)
+	}
+
+	// Parse the size budget
+	if maxSize != "" {
+		size, err := ParseSize(maxSize)
+		if err != nil {
+			return nil, err
+		}
+		cfg.MaxSize = size
+	}
+
+	// Parse the chapter split size, any zero size disabling splitting
+	if splitSize != "" {
+		size, err := parseSize(splitSize)
+		if err != nil {
+			return nil, err
+		}
+		cfg.SplitSize = size
+	}
+
+	// Split the subjects list
+	for _, subject := range strings.Split(subjects, ",") {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			cfg.Subjects = append(cfg.Subjects, subject)
+		}
+	}
+
+	// Parse the publication date
+	if date != "" {
+		parsed, err := time.Parse(time.DateOnly, date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q (expected YYYY-MM-DD)", date)
+		}
+		cfg.Date = parsed
+	}
+
+	// Validate the alt text mode
+	switch cfg.AltText {
+	case "caption", "title", "none":
+	default:
+		return nil, fmt.Errorf("invalid alt text mode %q (expected caption, title or none)", cfg.AltText)
+	}
 
 	// Set up temporary directory
 	if cfg.Debug {
@@ -60,6 +196,119 @@ func ParseCommandLine() (*Config, error) {
 	return cfg, nil
 }
 
+// HasFormat returns true if the given output format was requested
+func (c *Config) HasFormat(format string) bool {
+	for _, f := range c.Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// OutputName returns the output file name with its extension replaced, for the other output formats
+func (c *Config) OutputName(extension string) string {
+	return OutputName(c.OutputFile, extension)
+}
+
+// OutputName returns an EPUB or KEPUB file name with its extension replaced
+func OutputName(name string, extension string) string {
+	for _, suffix := range []string{".kepub.epub", ".epub"} {
+		if strings.HasSuffix(strings.ToLower(name), suffix) {
+			name = name[:len(name)-len(suffix)]
+			break
+		}
+	}
+	return name + extension
+}
+
+// stringList is a flag value collecting every occurrence of a repeated flag
+type stringList []string
+
+// String returns the collected values
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+// Set adds a value
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// pathFlags are the flags taking a file path, resolved by ResolvePaths
+var pathFlags = map[string]bool{"cover": true, "output": true, "urls": true, "omnibus": true, "rules": true, "glossary": true}
+
+// ResolvePaths resolves the relative file paths of command-line arguments against a folder
+//
+// URLs, absolute paths and the values of other flags are left unchanged.
+func ResolvePaths(args []string, dir string) []string {
+	resolved := append([]string(nil), args...)
+	for i := 0; i < len(resolved); i++ {
+		arg := resolved[i]
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !pathFlags[name] {
+			continue
+		}
+		if hasValue {
+			resolved[i] = arg[:len(arg)-len(value)] + resolvePath(dir, value)
+		} else if i+1 < len(resolved) {
+			i++
+			resolved[i] = resolvePath(dir, resolved[i])
+		}
+	}
+	return resolved
+}
+
+// resolvePath resolves a relative file path against a folder
+func resolvePath(dir string, name string) string {
+	if name == "" || strings.Contains(name, ":") || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
+
+// ParseSize parses a size in bytes with an optional K, KB, M, MB, G or GB suffix
+func ParseSize(value string) (int64, error) {
+	size, err := parseSize(value)
+	if err == nil && size <= 0 {
+		err = fmt.Errorf("invalid size %q (expected a number of bytes, optionally followed by KB, MB or GB)", value)
+	}
+	return size, err
+}
+
+// parseSize parses a size like ParseSize, also accepting zero
+func parseSize(value string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"GB", 1 << 30}, {"G", 1 << 30},
+		{"MB", 1 << 20}, {"M", 1 << 20},
+		{"KB", 1 << 10}, {"K", 1 << 10},
+		{"B", 1},
+	}
+
+	number := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q (expected a number of bytes, optionally followed by KB, MB or GB)", value)
+	}
+	return int64(size * float64(multiplier)), nil
+}
+
 // Cleanup removes the temporary directory if not in debug mode
 func (c *Config) Cleanup() {
 	if !c.Debug {
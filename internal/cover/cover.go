@@ -0,0 +1,209 @@
+package cover
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Cover dimensions, matching the usual 2:3 light novel cover ratio
+const (
+	Width  = 1600
+	Height = 2400
+	margin = 140
+)
+
+// Theme defines the colors of a generated cover
+type Theme struct {
+	Name       string
+	Background color.RGBA
+	Accent     color.RGBA
+	Title      color.RGBA
+	Text       color.RGBA
+}
+
+// themes holds the built-in cover themes
+var themes = map[string]Theme{
+	"midnight": {
+		Name:       "midnight",
+		Background: color.RGBA{R: 0x1d, G: 0x22, B: 0x3b, A: 0xff},
+		Accent:     color.RGBA{R: 0xe0, G: 0x8f, B: 0x5a, A: 0xff},
+		Title:      color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+		Text:       color.RGBA{R: 0xc8, G: 0xcc, B: 0xdc, A: 0xff},
+	},
+	"sakura": {
+		Name:       "sakura",
+		Background: color.RGBA{R: 0xfb, G: 0xe4, B: 0xea, A: 0xff},
+		Accent:     color.RGBA{R: 0xd9, G: 0x5b, B: 0x82, A: 0xff},
+		Title:      color.RGBA{R: 0x4a, G: 0x1c, B: 0x2c, A: 0xff},
+		Text:       color.RGBA{R: 0x6e, G: 0x3a, B: 0x4c, A: 0xff},
+	},
+	"paper": {
+		Name:       "paper",
+		Background: color.RGBA{R: 0xf6, G: 0xf1, B: 0xe7, A: 0xff},
+		Accent:     color.RGBA{R: 0x33, G: 0x33, B: 0x33, A: 0xff},
+		Title:      color.RGBA{R: 0x11, G: 0x11, B: 0x11, A: 0xff},
+		Text:       color.RGBA{R: 0x44, G: 0x44, B: 0x44, A: 0xff},
+	},
+}
+
+// ThemeNames returns the names of the built-in cover themes
+func ThemeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ThemeByName returns a built-in cover theme
+func ThemeByName(name string) (Theme, error) {
+	theme, ok := themes[strings.ToLower(name)]
+	if !ok {
+		return Theme{}, fmt.Errorf("unknown cover theme %q (expected one of %s)", name, strings.Join(ThemeNames(), ", "))
+	}
+	return theme, nil
+}
+
+// Options holds the text drawn on a generated cover
+type Options struct {
+	Title  string
+	Volume string
+	Author string
+	Theme  Theme
+}
+
+// Render draws a cover image from the title, volume number and author and returns it as PNG
+func Render(opts Options) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	theme := opts.Theme
+	if theme.Name == "" {
+		theme = themes["midnight"]
+	}
+
+	// Background and decorative bands
+	draw.Draw(img, img.Bounds(), image.NewUniform(theme.Background), image.Point{}, draw.Src)
+	fillRect(img, image.Rect(0, 0, Width, 60), theme.Accent)
+	fillRect(img, image.Rect(0, Height-60, Width, Height), theme.Accent)
+	fillRect(img, image.Rect(margin, 1480, Width-margin, 1492), theme.Accent)
+
+	// Title, wrapped and shrunk until it fits above the separator
+	titleSize := 150.0
+	var titleFace font.Face
+	var lines []string
+	for {
+		var err error
+		titleFace, err = newFace(gobold.TTF, titleSize)
+		if err != nil {
+			return nil, err
+		}
+		lines = wrapText(titleFace, opts.Title, Width-2*margin)
+		lineHeight := titleFace.Metrics().Height.Ceil()
+		if len(lines)*lineHeight <= 1000 || titleSize <= 60 {
+			break
+		}
+		titleSize -= 10
+	}
+
+	lineHeight := titleFace.Metrics().Height.Ceil()
+	y := 300 + (1000-len(lines)*lineHeight)/2 + titleFace.Metrics().Ascent.Ceil()
+	for _, line := range lines {
+		drawCentered(img, titleFace, line, y, theme.Title)
+		y += lineHeight
+	}
+
+	// Volume number below the separator
+	if opts.Volume != "" {
+		volumeFace, err := newFace(goregular.TTF, 110)
+		if err != nil {
+			return nil, err
+		}
+		drawCentered(img, volumeFace, "Volume "+opts.Volume, 1700, theme.Accent)
+	}
+
+	// Author near the bottom
+	if opts.Author != "" {
+		authorFace, err := newFace(goitalic.TTF, 80)
+		if err != nil {
+			return nil, err
+		}
+		for i, line := range wrapText(authorFace, opts.Author, Width-2*margin) {
+			drawCentered(img, authorFace, line, 2100+i*authorFace.Metrics().Height.Ceil(), theme.Text)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("error encoding cover image: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// newFace creates a font face from embedded TrueType data
+func newFace(ttf []byte, size float64) (font.Face, error) {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing embedded font: %v", err)
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating font face: %v", err)
+	}
+	return face, nil
+}
+
+// wrapText splits text into lines fitting the given width in pixels
+func wrapText(face font.Face, text string, width int) []string {
+	var lines []string
+	var current string
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if current != "" {
+			candidate = current + " " + word
+		}
+		if current != "" && font.MeasureString(face, candidate).Ceil() > width {
+			lines = append(lines, current)
+			current = word
+		} else {
+			current = candidate
+		}
+	}
+	if current != "" {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+// drawCentered draws a line of text horizontally centered on the baseline y
+func drawCentered(img draw.Image, face font.Face, text string, y int, c color.Color) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: face,
+	}
+	x := (Width - d.MeasureString(text).Ceil()) / 2
+	d.Dot = fixed.P(x, y)
+	d.DrawString(text)
+}
+
+// fillRect fills a rectangle with a solid color
+func fillRect(img draw.Image, r image.Rectangle, c color.Color) {
+	draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
@@ -28,12 +28,21 @@ func New(tempDir string, debug bool) *Downloader {
 }
 
 // DownloadFile downloads a file from a URL or uses cached version in debug mode
+//
+// Besides http(s) URLs, file:// URLs, plain local paths and data: URLs are
+// accepted. They are cached in debug mode like downloaded files, so an edited
+// local file is only read again once the cache is cleared.
 func (d *Downloader) DownloadFile(url string, filename string) ([]byte, error) {
 	// Handle empty or invalid URLs
 	if url == "" {
 		return nil, fmt.Errorf("empty URL provided")
 	}
 
+	kind, err := sourceKind(url)
+	if err != nil {
+		return nil, err
+	}
+
 	// If in debug mode and filename is provided, check if the file already exists
 	if d.debug && filename != "" {
 		tempFilePath := filepath.Join(d.tempDir, filename)
@@ -45,6 +54,15 @@ func (d *Downloader) DownloadFile(url string, filename string) ([]byte, error) {
 		}
 	}
 
+	if kind != sourceHTTP {
+		data, err := d.readLocal(url, kind)
+		if err != nil {
+			return nil, err
+		}
+		d.cacheFile(filename, data)
+		return data, nil
+	}
+
 	// Create a client with timeout
 	client := &http.Client{
 		Timeout: 30 * time.Second,
@@ -80,17 +98,46 @@ func (d *Downloader) DownloadFile(url string, filename string) ([]byte, error) {
 		return nil, fmt.Errorf("zero bytes received")
 	}
 
-	// If in debug mode and filename is provided, save the file for future use
-	if d.debug && filename != "" {
-		tempFilePath := filepath.Join(d.tempDir, filename)
-		if err := os.WriteFile(tempFilePath, buf.Bytes(), 0600); err != nil {
-			slog.Warn("Could not cache file", "path", tempFilePath, "error", err)
-		} else if logger.Debug {
-			slog.Debug("Cached file", "path", tempFilePath)
+	d.cacheFile(filename, buf.Bytes())
+	return buf.Bytes(), nil
+}
+
+// cacheFile saves a file for future use when in debug mode and filename is provided
+func (d *Downloader) cacheFile(filename string, data []byte) {
+	if !d.debug || filename == "" {
+		return
+	}
+	tempFilePath := filepath.Join(d.tempDir, filename)
+	if err := os.WriteFile(tempFilePath, data, 0600); err != nil {
+		slog.Warn("Could not cache file", "path", tempFilePath, "error", err)
+	} else if logger.Debug {
+		slog.Debug("Cached file", "path", tempFilePath)
+	}
+}
+
+// readLocal reads a local file or decodes a data: URL
+func (d *Downloader) readLocal(url string, kind int) ([]byte, error) {
+	var data []byte
+	var err error
+	if kind == sourceData {
+		if logger.Debug {
+			slog.Debug("Decoding data URL", "url", ShortURL(url))
+		}
+		data, err = decodeDataURL(url)
+	} else {
+		if logger.Debug {
+			slog.Debug("Reading local file", "url", url)
 		}
+		data, err = readLocalFile(url)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return buf.Bytes(), nil
+	if len(data) == 0 {
+		return nil, fmt.Errorf("zero bytes received")
+	}
+	return data, nil
 }
 
 // SaveToFile saves data to a file in the temporary directory
@@ -0,0 +1,127 @@
+package downloader
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Source kinds understood by DownloadFile
+const (
+	sourceHTTP = iota
+	sourceFile
+	sourceData
+)
+
+// sourceKind tells how a URL passed to DownloadFile must be read
+//
+// Anything without a scheme, or with a single-letter Windows drive "scheme",
+// is treated as a plain local path.
+func sourceKind(rawURL string) (int, error) {
+	if strings.HasPrefix(strings.ToLower(rawURL), "data:") {
+		return sourceData, nil
+	}
+
+	scheme, _, found := strings.Cut(rawURL, ":")
+	if !found || len(scheme) <= 1 || strings.ContainsAny(scheme, `/\.`) {
+		return sourceFile, nil
+	}
+
+	switch strings.ToLower(scheme) {
+	case "http", "https":
+		return sourceHTTP, nil
+	case "file":
+		return sourceFile, nil
+	}
+	return 0, fmt.Errorf("unsupported URL scheme %q", scheme)
+}
+
+// IsLocal checks whether a URL refers to a local file or a data: URL rather than a remote resource
+func IsLocal(rawURL string) bool {
+	kind, err := sourceKind(rawURL)
+	return err == nil && kind != sourceHTTP
+}
+
+// localPath converts a file:// URL or a plain path to a filesystem path
+func localPath(rawURL string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(rawURL), "file:") {
+		return rawURL, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid file URL: %v", err)
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return "", fmt.Errorf("file URL on remote host %q is not supported", u.Host)
+	}
+
+	// file:relative/path has no path, only an opaque part
+	path := u.Path
+	if path == "" {
+		path = u.Opaque
+	}
+
+	// file:///C:/cover.png becomes C:/cover.png on Windows
+	if len(path) >= 3 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path), nil
+}
+
+// readLocalFile reads a file:// URL or a plain local path
+func readLocalFile(rawURL string) ([]byte, error) {
+	path, err := localPath(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading local file: %v", err)
+	}
+	return data, nil
+}
+
+// decodeDataURL decodes the payload of an RFC 2397 data: URL
+//
+// The declared media type is ignored, the format is detected from the content
+// like for downloaded files.
+func decodeDataURL(rawURL string) ([]byte, error) {
+	header, payload, found := strings.Cut(rawURL[len("data:"):], ",")
+	if !found {
+		return nil, fmt.Errorf("invalid data URL: missing comma")
+	}
+
+	if !strings.HasSuffix(strings.ToLower(header), ";base64") {
+		data, err := url.PathUnescape(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data URL: %v", err)
+		}
+		return []byte(data), nil
+	}
+
+	// Inlined images are often wrapped or URL-escaped
+	payload, err := url.PathUnescape(payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data URL: %v", err)
+	}
+	payload = strings.Join(strings.Fields(payload), "")
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if data, err := enc.DecodeString(payload); err == nil {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid data URL: malformed base64 payload")
+}
+
+// ShortURL shortens data: URLs for log messages
+func ShortURL(rawURL string) string {
+	if len(rawURL) > 64 && strings.HasPrefix(strings.ToLower(rawURL), "data:") {
+		return rawURL[:48] + fmt.Sprintf("...(%d bytes)", len(rawURL))
+	}
+	return rawURL
+}
@@ -0,0 +1,211 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ynsta/seireitranslations-epub/internal/book"
+)
+
+// manifestXHTMLRe matches the manifest items of XHTML documents, capturing their href
+var manifestXHTMLRe = regexp.MustCompile(`<item [^>]*href="([^"]+)" media-type="application/xhtml\+xml"[^>]*>`)
+
+// rootfileRe finds the package document path in META-INF/container.xml
+var rootfileRe = regexp.MustCompile(`full-path="([^"]+)"`)
+
+// archiveFile is a file read from an EPUB archive
+type archiveFile struct {
+	header *zip.FileHeader
+	data   []byte
+}
+
+// readArchive reads all the files of an EPUB archive in their original order
+func readArchive(filename string) ([]*archiveFile, error) {
+	reader, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening EPUB archive: %v", err)
+	}
+	defer reader.Close()
+
+	var files []*archiveFile
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("error opening %s in EPUB archive: %v", f.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s in EPUB archive: %v", f.Name, err)
+		}
+
+		header := f.FileHeader
+		files = append(files, &archiveFile{header: &header, data: data})
+	}
+	return files, nil
+}
+
+// ReadImages reads the images of an EPUB written by the generator, by file name
+func ReadImages(filename string) (map[string][]byte, error) {
+	files, err := readArchive(filename)
+	if err != nil {
+		return nil, err
+	}
+	opf, err := packageDocument(files)
+	if err != nil {
+		return nil, err
+	}
+
+	folder := path.Join(path.Dir(opf.header.Name), book.ImageFolder) + "/"
+	images := make(map[string][]byte)
+	for _, f := range files {
+		if strings.HasPrefix(f.header.Name, folder) {
+			images[strings.TrimPrefix(f.header.Name, folder)] = f.data
+		}
+	}
+	return images, nil
+}
+
+// writeArchive writes files to an EPUB archive, replacing it atomically
+//
+// The mimetype file is always stored uncompressed, as required by the OCF
+// specification; the other files are compressed.
+func writeArchive(filename string, files []*archiveFile) error {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, f := range files {
+		header := &zip.FileHeader{
+			Name:   f.header.Name,
+			Method: zip.Deflate,
+		}
+
+		// go-epub leaves MS-DOS timestamps empty, which can't be expressed as a time
+		if f.header.Modified.Year() >= 1980 {
+			header.Modified = f.header.Modified
+		} else {
+			header.ModifiedDate, header.ModifiedTime = f.header.ModifiedDate, f.header.ModifiedTime
+		}
+		if f.header.Name == "mimetype" {
+			header.Method = zip.Store
+		}
+
+		w, err := writer.CreateHeader(header)
+		if err != nil {
+			return fmt.Errorf("error adding %s to EPUB archive: %v", f.header.Name, err)
+		}
+		if _, err := w.Write(f.data); err != nil {
+			return fmt.Errorf("error writing %s to EPUB archive: %v", f.header.Name, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("error finishing EPUB archive: %v", err)
+	}
+
+	tempFile := filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err := os.WriteFile(tempFile, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing EPUB archive: %v", err)
+	}
+	if err := os.Rename(tempFile, filename); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("error replacing EPUB archive: %v", err)
+	}
+	return nil
+}
+
+// findFile returns the file with the given name, or nil
+func findFile(files []*archiveFile, name string) *archiveFile {
+	for _, f := range files {
+		if f.header.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// packageDocument returns the package document (OPF) of an EPUB archive
+func packageDocument(files []*archiveFile) (*archiveFile, error) {
+	container := findFile(files, "META-INF/container.xml")
+	if container == nil {
+		return nil, fmt.Errorf("missing META-INF/container.xml")
+	}
+	match := rootfileRe.FindSubmatch(container.data)
+	if match == nil {
+		return nil, fmt.Errorf("no rootfile in META-INF/container.xml")
+	}
+	opf := findFile(files, string(match[1]))
+	if opf == nil {
+		return nil, fmt.Errorf("missing package document %s", match[1])
+	}
+	return opf, nil
+}
+
+// finishArchive post-processes the EPUB written by go-epub and writes the requested formats
+//
+// It declares the manifest properties go-epub omits, completes the navigation
+// document, adds the metadata go-epub doesn't support, dates updated books
+// with their revision and, in reproducible mode, removes everything that
+// changes from one build to the next. It returns
+// the names of the files written.
+func (g *Generator) finishArchive(source string, format OutputFormat) ([]string, error) {
+	files, err := readArchive(source)
+	if err != nil {
+		return nil, err
+	}
+	opf, err := packageDocument(files)
+	if err != nil {
+		return nil, err
+	}
+
+	declareSVGProperties(files, opf)
+	g.fixSplitLinks(files, opf)
+	files = g.completeNavigation(files, opf)
+	if err := g.addMetadata(opf); err != nil {
+		return nil, err
+	}
+	g.setModified(opf)
+	if g.reproducible {
+		files = g.makeReproducible(files, opf)
+	}
+
+	var written []string
+	if format&FormatEPUB != 0 {
+		if err := writeArchive(g.outputFile, files); err != nil {
+			return nil, err
+		}
+		written = append(written, g.outputFile)
+	}
+	if format&FormatKEPUB != 0 {
+		filename := KEPUBFilename(g.outputFile)
+		if err := g.writeKEPUB(files, opf, filename); err != nil {
+			return nil, err
+		}
+		written = append(written, filename)
+	}
+	return written, nil
+}
+
+// declareSVGProperties adds the svg property to the manifest items of documents embedding SVG
+//
+// go-epub doesn't know about the SVG wrappers of full-page illustrations, and
+// reading systems may refuse to render SVG in documents without the property.
+func declareSVGProperties(files []*archiveFile, opf *archiveFile) {
+	base := path.Dir(opf.header.Name)
+	opf.data = manifestXHTMLRe.ReplaceAllFunc(opf.data, func(item []byte) []byte {
+		href := manifestXHTMLRe.FindSubmatch(item)[1]
+		doc := findFile(files, path.Join(base, string(href)))
+		if doc == nil || !bytes.Contains(doc.data, []byte("<svg")) {
+			return item
+		}
+		if bytes.Contains(item, []byte(`properties="`)) {
+			return bytes.Replace(item, []byte(`properties="`), []byte(`properties="svg `), 1)
+		}
+		return bytes.Replace(item, []byte(`media-type="application/xhtml+xml"`), []byte(`media-type="application/xhtml+xml" properties="svg"`), 1)
+	})
+}
@@ -0,0 +1,155 @@
+package epub
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// OutputFormat selects the files written by Generator.Write
+type OutputFormat int
+
+// Output formats, which can be combined to write both files
+const (
+	FormatEPUB OutputFormat = 1 << iota
+	FormatKEPUB
+)
+
+// kepubSuffix is the file extension Kobo devices recognize as KEPUB
+const kepubSuffix = ".kepub.epub"
+
+// markupRe splits XHTML into tags, comments and text
+var markupRe = regexp.MustCompile(`<!--[\s\S]*?-->|<[^>]*>`)
+
+// tagNameRe captures the name of a start or end tag
+var tagNameRe = regexp.MustCompile(`^</?([a-zA-Z][a-zA-Z0-9:-]*)`)
+
+// sentenceRe matches a sentence and the whitespace following it
+var sentenceRe = regexp.MustCompile(`(?s)\S.*?(?:[.!?…]+["'”’»)\]]*(?:\s+|$)|$)`)
+
+// kepubParagraphs are the elements starting a new Kobo paragraph
+var kepubParagraphs = map[string]bool{
+	"p": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"li": true, "td": true, "th": true, "dt": true, "dd": true,
+	"div": true, "blockquote": true, "figcaption": true, "pre": true,
+}
+
+// kepubSkipped are the elements whose text must not be wrapped
+var kepubSkipped = map[string]bool{
+	"svg": true, "math": true, "script": true, "style": true,
+}
+
+// KEPUBFilename returns the name of the KEPUB written next to an EPUB
+func KEPUBFilename(filename string) string {
+	if strings.HasSuffix(strings.ToLower(filename), kepubSuffix) {
+		return filename
+	}
+	if strings.HasSuffix(strings.ToLower(filename), ".epub") {
+		filename = filename[:len(filename)-len(".epub")]
+	}
+	return filename + kepubSuffix
+}
+
+// writeKEPUB converts the files of a finished EPUB to Kobo markup and writes them as a KEPUB
+func (g *Generator) writeKEPUB(files []*archiveFile, opf *archiveFile, filename string) error {
+	base := path.Dir(opf.header.Name)
+	nav := path.Join(base, "nav.xhtml")
+
+	converted := make([]*archiveFile, 0, len(files))
+	for _, f := range files {
+		if strings.HasSuffix(f.header.Name, ".xhtml") && f.header.Name != nav {
+			header := *f.header
+			f = &archiveFile{header: &header, data: []byte(kepubify(string(f.data)))}
+		}
+		converted = append(converted, f)
+	}
+
+	if err := writeArchive(filename, converted); err != nil {
+		return fmt.Errorf("error writing KEPUB: %v", err)
+	}
+	return nil
+}
+
+// kepubify wraps the text of an XHTML document in koboSpan elements
+//
+// Kobo devices use the spans to track reading position and statistics, and
+// the book-columns and book-inner wrappers for their pagination.
+func kepubify(doc string) string {
+	var b strings.Builder
+	inBody := false
+	skipped := 0
+	paragraph, sentence := 0, 0
+
+	last := 0
+	for _, loc := range markupRe.FindAllStringIndex(doc, -1) {
+		if inBody && skipped == 0 {
+			paragraph, sentence = wrapSentences(&b, doc[last:loc[0]], paragraph, sentence)
+		} else {
+			b.WriteString(doc[last:loc[0]])
+		}
+		last = loc[1]
+
+		tag := doc[loc[0]:loc[1]]
+		match := tagNameRe.FindStringSubmatch(tag)
+		if match == nil {
+			b.WriteString(tag)
+			continue
+		}
+		name := strings.ToLower(match[1])
+		end := strings.HasPrefix(tag, "</")
+		selfClosing := strings.HasSuffix(tag, "/>")
+
+		switch {
+		case name == "body" && end:
+			b.WriteString("</div></div>")
+			b.WriteString(tag)
+			inBody = false
+			continue
+		case name == "body":
+			b.WriteString(tag)
+			b.WriteString(`<div id="book-columns"><div id="book-inner">`)
+			inBody = true
+			continue
+		case kepubSkipped[name] && !selfClosing:
+			if end {
+				skipped--
+			} else {
+				skipped++
+			}
+		case kepubParagraphs[name] && !end:
+			paragraph++
+			sentence = 0
+		}
+		b.WriteString(tag)
+	}
+	b.WriteString(doc[last:])
+
+	return b.String()
+}
+
+// wrapSentences writes a text node with each sentence wrapped in a koboSpan
+//
+// It returns the paragraph and sentence counters to continue from.
+func wrapSentences(b *strings.Builder, text string, paragraph, sentence int) (int, int) {
+	if strings.TrimSpace(text) == "" {
+		b.WriteString(text)
+		return paragraph, sentence
+	}
+
+	// Text before the first block element still needs a paragraph number
+	if paragraph == 0 {
+		paragraph = 1
+	}
+
+	last := 0
+	for _, loc := range sentenceRe.FindAllStringIndex(text, -1) {
+		sentence++
+		b.WriteString(text[last:loc[0]])
+		fmt.Fprintf(b, `<span class="koboSpan" id="kobo.%d.%d">%s</span>`, paragraph, sentence, text[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(text[last:])
+
+	return paragraph, sentence
+}
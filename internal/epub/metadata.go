@@ -0,0 +1,89 @@
+package epub
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/ynsta/seireitranslations-epub/internal/book"
+)
+
+// metadataElements renders the metadata go-epub cannot write itself as package document elements
+func metadataElements(m book.Metadata) string {
+	var b strings.Builder
+
+	for i, c := range m.Contributors {
+		id := fmt.Sprintf("contributor%d", i+1)
+		element := "dc:contributor"
+		if c.Role == book.RoleAuthor {
+			element = "dc:creator"
+		}
+		fmt.Fprintf(&b, "    <%s id=\"%s\">%s</%s>\n", element, id, html.EscapeString(c.Name), element)
+		fmt.Fprintf(&b, "    <meta refines=\"#%s\" property=\"role\" scheme=\"marc:relators\">%s</meta>\n", id, c.Role)
+	}
+
+	if m.Publisher != "" {
+		fmt.Fprintf(&b, "    <dc:publisher>%s</dc:publisher>\n", html.EscapeString(m.Publisher))
+	}
+	if !m.Date.IsZero() {
+		fmt.Fprintf(&b, "    <dc:date>%s</dc:date>\n", m.Date.Format(time.DateOnly))
+	}
+	for _, subject := range m.Subjects {
+		fmt.Fprintf(&b, "    <dc:subject>%s</dc:subject>\n", html.EscapeString(subject))
+	}
+
+	if m.Series != "" {
+		series := html.EscapeString(m.Series)
+		b.WriteString("    <meta property=\"belongs-to-collection\" id=\"series\">" + series + "</meta>\n")
+		b.WriteString("    <meta refines=\"#series\" property=\"collection-type\">series</meta>\n")
+		if m.SeriesIndex != "" {
+			b.WriteString("    <meta refines=\"#series\" property=\"group-position\">" + m.SeriesIndex + "</meta>\n")
+		}
+		b.WriteString("    <meta name=\"calibre:series\" content=\"" + series + "\"></meta>\n")
+		if m.SeriesIndex != "" {
+			b.WriteString("    <meta name=\"calibre:series_index\" content=\"" + m.SeriesIndex + "\"></meta>\n")
+		}
+	}
+
+	if m.Revision > 0 {
+		fmt.Fprintf(&b, "    <meta property=\"schema:version\">%d</meta>\n", m.Revision)
+	}
+
+	return b.String()
+}
+
+// addMetadata adds the metadata go-epub doesn't support to the package document
+func (g *Generator) addMetadata(opf *archiveFile) error {
+	elements := metadataElements(g.book.Metadata)
+	if elements == "" {
+		return nil
+	}
+
+	end := bytes.Index(opf.data, []byte("</metadata>"))
+	if end < 0 {
+		return fmt.Errorf("no metadata element in package document")
+	}
+
+	// Insert the elements at the start of the closing tag line to keep the indentation
+	lineStart := bytes.LastIndexByte(opf.data[:end], '\n') + 1
+	opf.data = append(opf.data[:lineStart:lineStart], append([]byte(elements), opf.data[lineStart:]...)...)
+	return nil
+}
+
+// setModified writes the modification date of the package document
+//
+// go-epub writes the build time. An updated book gets its revision date
+// instead, and a reproducible build its fixed date.
+func (g *Generator) setModified(opf *archiveFile) {
+	modified := g.book.Metadata.Revised
+	if g.reproducible {
+		modified = g.reproducibleModified()
+	}
+	if modified.IsZero() {
+		return
+	}
+	date := modified.UTC().Format("2006-01-02T15:04:05Z")
+	opf.data = modifiedRe.ReplaceAll(opf.data, []byte(`<meta property="dcterms:modified">`+date+`</meta>`))
+}
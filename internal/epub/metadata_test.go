@@ -0,0 +1,83 @@
+package epub
+
+import (
+	"archive/zip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ynsta/seireitranslations-epub/internal/book"
+)
+
+func TestSetModified(t *testing.T) {
+	const built = "2026-10-18T09:30:00Z"
+	published := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	revised := time.Date(2024, 5, 2, 12, 34, 56, 0, time.FixedZone("JST", 9*60*60))
+
+	tests := []struct {
+		name         string
+		metadata     book.Metadata
+		reproducible bool
+		epoch        string
+		want         string
+	}{
+		{
+			name: "first build keeps the build time",
+			want: built,
+		},
+		{
+			name:     "first build with a publication date keeps the build time",
+			metadata: book.Metadata{Date: published},
+			want:     built,
+		},
+		{
+			name:     "updated book",
+			metadata: book.Metadata{Date: published, Revision: 2, Revised: revised},
+			want:     "2024-05-02T03:34:56Z",
+		},
+		{
+			name:         "reproducible updated book",
+			metadata:     book.Metadata{Date: published, Revision: 2, Revised: revised},
+			reproducible: true,
+			want:         "2024-05-02T03:34:56Z",
+		},
+		{
+			name:         "reproducible first build",
+			metadata:     book.Metadata{Date: published},
+			reproducible: true,
+			want:         "2024-03-01T00:00:00Z",
+		},
+		{
+			name:         "reproducible build without dates",
+			reproducible: true,
+			want:         "2000-01-01T00:00:00Z",
+		},
+		{
+			name:         "SOURCE_DATE_EPOCH comes first",
+			metadata:     book.Metadata{Date: published, Revision: 2, Revised: revised},
+			reproducible: true,
+			epoch:        "1700000000",
+			want:         "2023-11-14T22:13:20Z",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("SOURCE_DATE_EPOCH", test.epoch)
+			opf := &archiveFile{header: &zip.FileHeader{Name: "EPUB/package.opf"}, data: []byte(`<metadata>
+    <dc:title>Test Book</dc:title>
+    <meta property="dcterms:modified">` + built + `</meta>
+  </metadata>`)}
+			g := &Generator{book: &book.Book{Metadata: test.metadata}, reproducible: test.reproducible}
+			g.setModified(opf)
+
+			want := `<meta property="dcterms:modified">` + test.want + `</meta>`
+			if !strings.Contains(string(opf.data), want) {
+				t.Errorf("package document has no %s:\n%s", want, opf.data)
+			}
+			if count := strings.Count(string(opf.data), "dcterms:modified"); count != 1 {
+				t.Errorf("%d dcterms:modified elements, want 1", count)
+			}
+		})
+	}
+}
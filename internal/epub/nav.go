@@ -0,0 +1,260 @@
+package epub
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/ynsta/seireitranslations-epub/internal/book"
+)
+
+// pageLength is the number of text characters per synthetic page of the page list
+const pageLength = 2000
+
+// paragraphStartRe matches the start of block elements where a page break can be placed
+var paragraphStartRe = regexp.MustCompile(`<(p|h[1-6]|div|blockquote|ul|ol|dl|table|figure)[ >]`)
+
+// tagRe matches markup, to count the text between page breaks
+var tagRe = regexp.MustCompile(`<[^>]*>`)
+
+// ncxItemRe matches the manifest item of the NCX
+var ncxItemRe = regexp.MustCompile(`(?m)^[ \t]*<item [^>]*media-type="application/x-dtbncx\+xml"[^>]*>(</item>)?\n`)
+
+// landmark is an entry of the landmarks navigation
+type landmark struct {
+	epubType string
+	href     string
+	label    string
+}
+
+// tocEntry is an entry of the table of contents with its nested entries
+type tocEntry struct {
+	title    string
+	href     string
+	children []*tocEntry
+}
+
+// pageBreak is an entry of the page list navigation
+type pageBreak struct {
+	number int
+	href   string
+}
+
+// addPageBreaks inserts page break markers into a section body every pageLength
+// characters of text, at the start of a block element
+//
+// The first page of every section starts at its beginning. It returns the new
+// body and the numbers of the pages it contains.
+func addPageBreaks(body string, nextPage int) (string, []int) {
+	var b strings.Builder
+	var pages []int
+	marker := func() {
+		fmt.Fprintf(&b, `<span epub:type="pagebreak" role="doc-pagebreak" id="page-%d" aria-label="%d"></span>`, nextPage, nextPage)
+		pages = append(pages, nextPage)
+		nextPage++
+	}
+
+	last := 0
+	count := 0
+	for i, loc := range paragraphStartRe.FindAllStringIndex(body, -1) {
+		count += len([]rune(html.UnescapeString(tagRe.ReplaceAllString(body[last:loc[0]], ""))))
+		b.WriteString(body[last:loc[0]])
+		if i == 0 || count >= pageLength {
+			marker()
+			count = 0
+		}
+		last = loc[0]
+	}
+	if len(pages) == 0 {
+		marker()
+	}
+	b.WriteString(body[last:])
+
+	return b.String(), pages
+}
+
+// navigationMarkup renders the landmarks and page list navigation elements
+func navigationMarkup(landmarks []landmark, pages []pageBreak) string {
+	var b strings.Builder
+
+	if len(landmarks) > 0 {
+		b.WriteString("    <nav epub:type=\"landmarks\" hidden=\"\">\n      <h2>Landmarks</h2>\n      <ol>\n")
+		for _, l := range landmarks {
+			fmt.Fprintf(&b, "        <li>\n          <a epub:type=\"%s\" href=\"%s\">%s</a>\n        </li>\n",
+				l.epubType, html.EscapeString(l.href), html.EscapeString(l.label))
+		}
+		b.WriteString("      </ol>\n    </nav>\n")
+	}
+
+	if len(pages) > 0 {
+		b.WriteString("    <nav epub:type=\"page-list\" hidden=\"\">\n      <h2>Pages</h2>\n      <ol>\n")
+		for _, p := range pages {
+			fmt.Fprintf(&b, "        <li>\n          <a href=\"%s#page-%d\">%d</a>\n        </li>\n", html.EscapeString(p.href), p.number, p.number)
+		}
+		b.WriteString("      </ol>\n    </nav>\n")
+	}
+
+	return b.String()
+}
+
+// completeNavigation adds landmarks and the page list to the navigation document
+// and removes the NCX if it isn't wanted
+func (g *Generator) completeNavigation(files []*archiveFile, opf *archiveFile) []*archiveFile {
+	base := path.Dir(opf.header.Name)
+
+	// go-epub names the navigation document nav.xhtml next to the package document
+	if nav := findFile(files, path.Join(base, "nav.xhtml")); nav != nil {
+		if markup := navigationMarkup(g.landmarks(), g.pages); markup != "" {
+			end := bytes.LastIndex(nav.data, []byte("</body>"))
+			if end >= 0 {
+				nav.data = append(nav.data[:end:end], append([]byte(markup), nav.data[end:]...)...)
+			}
+		}
+	}
+
+	// go-epub can't nest entries reliably, so nested tables of contents are rebuilt
+	if entries, nested := g.tableOfContents(); nested {
+		if nav := findFile(files, path.Join(base, "nav.xhtml")); nav != nil {
+			nav.data = replaceTOCList(nav.data, entries)
+		}
+		if ncx := findFile(files, path.Join(base, "toc.ncx")); ncx != nil {
+			ncx.data = replaceNavMap(ncx.data, entries)
+		}
+	}
+
+	if g.omitNCX {
+		opf.data = ncxItemRe.ReplaceAll(opf.data, nil)
+		opf.data = bytes.Replace(opf.data, []byte(`<spine toc="ncx">`), []byte(`<spine>`), 1)
+		kept := files[:0]
+		for _, f := range files {
+			if f.header.Name != path.Join(base, "toc.ncx") {
+				kept = append(kept, f)
+			}
+		}
+		files = kept
+	}
+
+	return files
+}
+
+// landmarks lists the cover, table of contents and the first section of each structural type
+func (g *Generator) landmarks() []landmark {
+	var landmarks []landmark
+	if g.coverPath != "" {
+		landmarks = append(landmarks, landmark{epubType: "cover", href: "xhtml/cover.xhtml", label: "Cover"})
+	}
+	landmarks = append(landmarks, landmark{epubType: "toc", href: "nav.xhtml", label: "Table of Contents"})
+
+	labels := map[string]string{
+		book.TypeCredits:    "Attribution",
+		book.TypeBodymatter: "Start of Content",
+		book.TypeBackmatter: "Back Matter",
+	}
+	for _, epubType := range []string{book.TypeCredits, book.TypeBodymatter, book.TypeBackmatter} {
+		for _, section := range g.written {
+			if section.Type != epubType {
+				continue
+			}
+			label := labels[epubType]
+			if epubType != book.TypeBodymatter && section.Title != "" {
+				label = section.Title
+			}
+			landmarks = append(landmarks, landmark{epubType: epubType, href: "xhtml/" + section.Filename, label: label})
+			break
+		}
+	}
+	return landmarks
+}
+
+// tableOfContents builds the table of contents from the levels of the titled sections
+//
+// It returns whether any entry is nested.
+func (g *Generator) tableOfContents() ([]*tocEntry, bool) {
+	var root []*tocEntry
+	var parents []*tocEntry
+	nested := false
+	for _, section := range g.written {
+		if section.Title == "" {
+			continue
+		}
+		entry := &tocEntry{title: section.Title, href: "xhtml/" + section.Filename}
+
+		// Attach the entry to the closest section of a lower level
+		level := min(section.Level, len(parents))
+		parents = append(parents[:level], entry)
+		if level == 0 {
+			root = append(root, entry)
+		} else {
+			parents[level-1].children = append(parents[level-1].children, entry)
+			nested = true
+		}
+	}
+	return root, nested
+}
+
+// replaceTOCList replaces the list of the toc navigation element of a navigation document
+func replaceTOCList(data []byte, entries []*tocEntry) []byte {
+	nav := bytes.Index(data, []byte(`<nav epub:type="toc"`))
+	if nav < 0 {
+		return data
+	}
+	end := bytes.Index(data[nav:], []byte("</nav>"))
+	start := bytes.Index(data[nav:], []byte("<ol>"))
+	if end < 0 || start < 0 || start > end {
+		return data
+	}
+	listEnd := bytes.LastIndex(data[nav:nav+end], []byte("</ol>"))
+	if listEnd < start {
+		return data
+	}
+
+	var b strings.Builder
+	writeTOCList(&b, entries, "      ")
+	list := strings.TrimPrefix(strings.TrimSuffix(b.String(), "\n"), "      ")
+	return append(append(append([]byte(nil), data[:nav+start]...), list...), data[nav+listEnd+len("</ol>"):]...)
+}
+
+// writeTOCList renders entries as a nested navigation list
+func writeTOCList(b *strings.Builder, entries []*tocEntry, indent string) {
+	b.WriteString(indent + "<ol>\n")
+	for _, e := range entries {
+		b.WriteString(indent + "  <li>\n")
+		fmt.Fprintf(b, "%s    <a href=\"%s\">%s</a>\n", indent, html.EscapeString(e.href), html.EscapeString(e.title))
+		if len(e.children) > 0 {
+			writeTOCList(b, e.children, indent+"    ")
+		}
+		b.WriteString(indent + "  </li>\n")
+	}
+	b.WriteString(indent + "</ol>\n")
+}
+
+// replaceNavMap replaces the navigation points of an NCX with nested ones
+func replaceNavMap(data []byte, entries []*tocEntry) []byte {
+	start := bytes.Index(data, []byte("<navMap>"))
+	end := bytes.Index(data, []byte("</navMap>"))
+	if start < 0 || end < start {
+		return data
+	}
+
+	var b strings.Builder
+	b.WriteString("<navMap>\n")
+	id := 0
+	writeNavPoints(&b, entries, "    ", &id)
+	b.WriteString("  ")
+	return append(append(append([]byte(nil), data[:start]...), b.String()...), data[end:]...)
+}
+
+// writeNavPoints renders entries as nested NCX navigation points, numbered in reading order
+func writeNavPoints(b *strings.Builder, entries []*tocEntry, indent string, id *int) {
+	for _, e := range entries {
+		*id++
+		fmt.Fprintf(b, "%s<navPoint id=\"navPoint-%d\">\n", indent, *id)
+		fmt.Fprintf(b, "%s  <navLabel>\n%s    <text>%s</text>\n%s  </navLabel>\n", indent, indent, html.EscapeString(e.title), indent)
+		fmt.Fprintf(b, "%s  <content src=\"%s\"></content>\n", indent, html.EscapeString(e.href))
+		writeNavPoints(b, e.children, indent+"  ", id)
+		fmt.Fprintf(b, "%s</navPoint>\n", indent)
+	}
+}
@@ -0,0 +1,111 @@
+package epub
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// identifierNamespace is the UUIDv5 namespace of stable book identifiers
+var identifierNamespace = uuid.NewV5(uuid.NamespaceURL, "https://github.com/ynsta/seireitranslations-epub")
+
+// fallbackModified is the modification date of reproducible builds without any other date
+var fallbackModified = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// modifiedRe matches the dcterms:modified meta element of the package document
+var modifiedRe = regexp.MustCompile(`<meta property="dcterms:modified">[^<]*</meta>`)
+
+// manifestItemRe matches the item elements of the package document manifest
+var manifestItemRe = regexp.MustCompile(`(?m)^[ \t]*<item [^\n]*\n`)
+
+// StableIdentifier derives a UUIDv5 book identifier from the book configuration
+//
+// The same parts always give the same identifier, so rebuilding a book
+// doesn't make readers treat it as a different book.
+func StableIdentifier(parts ...string) string {
+	return "urn:uuid:" + uuid.NewV5(identifierNamespace, strings.Join(parts, "\x00")).String()
+}
+
+// NewIdentifier returns a random book identifier, in the format go-epub uses
+func NewIdentifier() string {
+	return "urn:uuid:" + uuid.Must(uuid.NewV4()).String()
+}
+
+// reproducibleModified returns the fixed modification date of reproducible builds
+//
+// SOURCE_DATE_EPOCH takes precedence, then the revision date and the publication date.
+func (g *Generator) reproducibleModified() time.Time {
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC()
+	}
+	if !g.book.Metadata.Revised.IsZero() {
+		return g.book.Metadata.Revised.UTC()
+	}
+	if !g.book.Metadata.Date.IsZero() {
+		return g.book.Metadata.Date.UTC()
+	}
+	return fallbackModified
+}
+
+// makeReproducible removes the parts of the archive that change between builds
+//
+// Manifest items and archive entries are sorted, and every entry gets the
+// same timestamp. The modification date is fixed by setModified.
+func (g *Generator) makeReproducible(files []*archiveFile, opf *archiveFile) []*archiveFile {
+	opf.data = sortManifest(opf.data)
+
+	// The mimetype entry must stay first
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].header.Name == "mimetype" || files[j].header.Name == "mimetype" {
+			return files[i].header.Name == "mimetype"
+		}
+		return files[i].header.Name < files[j].header.Name
+	})
+	for _, f := range files {
+		f.header.Modified = time.Time{}
+		f.header.ModifiedDate, f.header.ModifiedTime = 0, 0
+	}
+
+	return files
+}
+
+// sortManifest sorts the manifest items, which go-epub writes in map order
+func sortManifest(data []byte) []byte {
+	start := bytes.Index(data, []byte("<manifest>"))
+	end := bytes.Index(data, []byte("</manifest>"))
+	if start < 0 || end < start {
+		return data
+	}
+
+	section := data[start:end]
+	items := manifestItemRe.FindAll(section, -1)
+	if len(items) == 0 {
+		return data
+	}
+	sort.Slice(items, func(i, j int) bool { return bytes.Compare(items[i], items[j]) < 0 })
+
+	// Items are contiguous lines, replace them in place
+	first := manifestItemRe.FindIndex(section)
+	var sorted bytes.Buffer
+	sorted.Write(data[:start+first[0]])
+	for _, item := range items {
+		sorted.Write(item)
+	}
+	sorted.Write(data[start+first[0]+totalLength(items):])
+	return sorted.Bytes()
+}
+
+// totalLength returns the combined length of byte slices
+func totalLength(parts [][]byte) int {
+	n := 0
+	for _, p := range parts {
+		n += len(p)
+	}
+	return n
+}
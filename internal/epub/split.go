@@ -0,0 +1,258 @@
+package epub
+
+import (
+	"html"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// splitContainers are the elements a section body can be split inside, by
+// closing them at the end of a part and opening them again in the next one
+var splitContainers = map[string]bool{"section": true, "div": true, "article": true}
+
+// voidElements are the elements written without an end tag in HTML
+var voidElements = map[string]bool{"br": true, "hr": true, "img": true, "wbr": true}
+
+// headingRe matches the start tag of a heading, the preferred place to split a section
+var headingRe = regexp.MustCompile(`^<h[1-6][ >]`)
+
+// idAttrRe matches an id attribute, dropped from the containers opened again in later parts
+var idAttrRe = regexp.MustCompile(`\sid="[^"]*"`)
+
+// idRe captures the id attributes of a document
+var idRe = regexp.MustCompile(`\sid="([^"]+)"`)
+
+// splitPoint is a place between two blocks where a section body can be split
+type splitPoint struct {
+	offset    int
+	heading   bool
+	container bool
+
+	// open holds the start tags of the containers open at the split point
+	open []string
+}
+
+// splitPart is a part of a section body split over several documents
+type splitPart struct {
+	body string
+
+	// title is the text of the heading starting the part, if any
+	title string
+}
+
+// splitBody splits a section body into parts of at most about maxSize bytes
+//
+// Parts end before a heading when there is one in the second half of the part,
+// else before the last block that fits. The containers enclosing the split
+// point are closed and opened again, so every part is well-formed.
+func splitBody(body string, maxSize int) []splitPart {
+	if maxSize <= 0 || len(body) <= maxSize {
+		return []splitPart{{body: body}}
+	}
+	points := splitPoints(body)
+
+	var cuts []splitPoint
+	start := 0
+	for len(body)-start > maxSize {
+		last, heading := -1, -1
+		content := false
+		for i, p := range points {
+			if p.offset < start {
+				continue
+			}
+
+			// Parts hold at least one block besides headings and containers
+			if p.offset > start && content {
+				if p.offset-start > maxSize {
+					if last < 0 {
+						last = i // A single block larger than the limit
+					}
+					break
+				}
+				last = i
+				if p.heading && p.offset-start > maxSize/2 {
+					heading = i
+				}
+			}
+			content = content || p.hasContent()
+		}
+		if last < 0 {
+			break
+		}
+		cut := last
+		if heading >= 0 {
+			cut = heading
+		}
+
+		// Keep headings with the content following them
+		if cut > 0 && points[cut-1].heading && !points[cut].heading && hasContent(points, start, points[cut-1].offset) {
+			cut--
+		}
+		cuts = append(cuts, points[cut])
+		start = points[cut].offset
+	}
+
+	var parts []splitPart
+	var previous splitPoint
+	for i := 0; i <= len(cuts); i++ {
+		next := splitPoint{offset: len(body)}
+		if i < len(cuts) {
+			next = cuts[i]
+		}
+
+		var b strings.Builder
+		part := splitPart{}
+		for _, tag := range previous.open {
+			b.WriteString(idAttrRe.ReplaceAllString(tag, ""))
+		}
+		b.WriteString(body[previous.offset:next.offset])
+		for j := len(next.open) - 1; j >= 0; j-- {
+			b.WriteString("</" + tagName(next.open[j]) + ">")
+		}
+		part.body = b.String()
+		if i > 0 && previous.heading {
+			part.title = headingText(body[previous.offset:])
+		}
+		parts = append(parts, part)
+		previous = next
+	}
+	return parts
+}
+
+// splitPoints returns the start tags of the blocks only enclosed by containers
+func splitPoints(body string) []splitPoint {
+	var points []splitPoint
+	var open []string
+	split := true
+	for _, loc := range markupRe.FindAllStringIndex(body, -1) {
+		tag := body[loc[0]:loc[1]]
+		match := tagNameRe.FindStringSubmatch(tag)
+		if match == nil {
+			continue
+		}
+		name := strings.ToLower(match[1])
+		switch {
+		case strings.HasPrefix(tag, "</"):
+			if len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+			split = allContainers(open)
+			continue
+		case split:
+			points = append(points, splitPoint{
+				offset:    loc[0],
+				heading:   headingRe.MatchString(tag),
+				container: splitContainers[name],
+				open:      append([]string(nil), open...),
+			})
+		}
+		if !strings.HasSuffix(tag, "/>") && !voidElements[name] {
+			open = append(open, tag)
+			split = split && splitContainers[name]
+		}
+	}
+	return points
+}
+
+// hasContent returns true if a point starts a block other than a heading or container
+func (p splitPoint) hasContent() bool {
+	return !p.heading && !p.container
+}
+
+// hasContent returns true if blocks other than headings and containers start between two offsets
+func hasContent(points []splitPoint, start int, end int) bool {
+	for _, p := range points {
+		if p.offset >= start && p.offset < end && p.hasContent() {
+			return true
+		}
+	}
+	return false
+}
+
+// allContainers returns true if all the open elements are split containers
+func allContainers(open []string) bool {
+	for _, tag := range open {
+		if !splitContainers[tagName(tag)] {
+			return false
+		}
+	}
+	return true
+}
+
+// tagName returns the lowercase name of a start or end tag
+func tagName(tag string) string {
+	if match := tagNameRe.FindStringSubmatch(tag); match != nil {
+		return strings.ToLower(match[1])
+	}
+	return ""
+}
+
+// headingText returns the text of the heading starting markup
+func headingText(markup string) string {
+	end := strings.Index(markup, "</h")
+	if end < 0 {
+		return ""
+	}
+	text := tagRe.ReplaceAllString(markup[:end], "")
+	return strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+}
+
+// fixSplitLinks points the links to the parts of split sections to the part holding their target
+//
+// Links to a split section use the file name of its first part, and links
+// within it are relative to the document they were written in.
+func (g *Generator) fixSplitLinks(files []*archiveFile, opf *archiveFile) {
+	if len(g.splits) == 0 {
+		return
+	}
+	folder := path.Join(path.Dir(opf.header.Name), "xhtml")
+	byName := make(map[string]*archiveFile)
+	for _, f := range files {
+		if path.Dir(f.header.Name) == folder {
+			byName[path.Base(f.header.Name)] = f
+		}
+	}
+
+	for _, parts := range g.splits {
+		// Find the part holding each id
+		targets := make(map[string]string)
+		for _, part := range parts {
+			if f := byName[part]; f != nil {
+				for _, match := range idRe.FindAllSubmatch(f.data, -1) {
+					targets[string(match[1])] = part
+				}
+			}
+		}
+
+		var pairs []string
+		for id, part := range targets {
+			if part != parts[0] {
+				pairs = append(pairs, `href="`+parts[0]+`#`+id+`"`, `href="`+part+`#`+id+`"`)
+			}
+		}
+		if len(pairs) > 0 {
+			replacer := strings.NewReplacer(pairs...)
+			for _, f := range byName {
+				f.data = []byte(replacer.Replace(string(f.data)))
+			}
+		}
+
+		// Links within the section may now point to another part
+		for _, part := range parts {
+			f := byName[part]
+			if f == nil {
+				continue
+			}
+			var local []string
+			for id, target := range targets {
+				if target != part {
+					local = append(local, `href="#`+id+`"`, `href="`+target+`#`+id+`"`)
+				}
+			}
+			if len(local) > 0 {
+				f.data = []byte(strings.NewReplacer(local...).Replace(string(f.data)))
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package epub
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+// paragraph returns a paragraph of about size bytes
+func paragraph(text string, size int) string {
+	return "<p>" + text + strings.Repeat(".", max(0, size-len(text)-7)) + "</p>"
+}
+
+func TestSplitBody(t *testing.T) {
+	long := paragraph("first", 100) + paragraph("second", 100) + paragraph("third", 100)
+	tests := []struct {
+		name    string
+		body    string
+		maxSize int
+
+		// parts is the expected number of parts
+		parts int
+
+		// starts holds the text each part after the first must start with, if given
+		starts []string
+
+		// titles holds the expected title of each part, if given
+		titles []string
+	}{
+		{
+			name:    "size 0 never splits",
+			body:    long,
+			maxSize: 0,
+			parts:   1,
+		},
+		{
+			name:    "body under the limit",
+			body:    long,
+			maxSize: len(long),
+			parts:   1,
+		},
+		{
+			name:    "between paragraphs",
+			body:    long,
+			maxSize: 250,
+			parts:   2,
+			starts:  []string{"<p>third"},
+		},
+		{
+			name:    "single block over the limit",
+			body:    paragraph("huge", 500),
+			maxSize: 100,
+			parts:   1,
+		},
+		{
+			name:    "blocks each over the limit",
+			body:    paragraph("one", 300) + paragraph("two", 300),
+			maxSize: 100,
+			parts:   2,
+			starts:  []string{"<p>two"},
+		},
+		{
+			name:    "no split point inside a block",
+			body:    "<blockquote>" + long + "</blockquote>",
+			maxSize: 100,
+			parts:   1,
+		},
+		{
+			name:    "inside nested containers",
+			body:    `<section id="chapter" class="chapter"><div class="text">` + long + `</div></section>`,
+			maxSize: 150,
+			parts:   3,
+			starts: []string{`<section class="chapter"><div class="text"><p>second`,
+				`<section class="chapter"><div class="text"><p>third`},
+		},
+		{
+			name: "not inside a list",
+			body: `<section>` + paragraph("intro", 100) + `<ul><li>` + paragraph("item", 100) + `</li><li>` +
+				paragraph("item", 100) + `</li></ul>` + paragraph("end", 100) + `</section>`,
+			maxSize: 150,
+			parts:   3,
+			starts:  []string{"<section><ul>", "<section><p>end"},
+		},
+		{
+			name: "before a heading",
+			body: paragraph("one", 100) + paragraph("two", 100) + "<h3>Part 2</h3>" +
+				paragraph("three", 100) + paragraph("four", 100),
+			maxSize: 300,
+			parts:   2,
+			starts:  []string{"<h3>Part 2</h3>"},
+			titles:  []string{"", "Part 2"},
+		},
+		{
+			name:    "heading kept with its content",
+			body:    paragraph("one", 100) + "<h3>Part 2</h3>" + paragraph("two", 100) + paragraph("three", 100),
+			maxSize: 140,
+			parts:   3,
+			starts:  []string{"<h3>Part 2</h3><p>two", "<p>three"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parts := splitBody(test.body, test.maxSize)
+			if len(parts) != test.parts {
+				t.Fatalf("got %d parts, want %d: %q", len(parts), test.parts, parts)
+			}
+			if len(parts) == 1 && parts[0].body != test.body {
+				t.Errorf("single part differs from the body: %q", parts[0].body)
+			}
+
+			var text strings.Builder
+			for i, part := range parts {
+				// Every part is well-formed on its own
+				decoder := xml.NewDecoder(strings.NewReader("<body>" + part.body + "</body>"))
+				decoder.Strict = true
+				for {
+					_, err := decoder.Token()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						t.Fatalf("part %d is not well-formed: %v\n%s", i, err, part.body)
+					}
+				}
+				if i > 0 && strings.Contains(part.body, `id="chapter"`) {
+					t.Errorf("part %d repeats the id of a container: %s", i, part.body)
+				}
+				if i > 0 && i-1 < len(test.starts) && !strings.HasPrefix(part.body, test.starts[i-1]) {
+					t.Errorf("part %d starts with %.60q, want %q", i, part.body, test.starts[i-1])
+				}
+				if i < len(test.titles) && part.title != test.titles[i] {
+					t.Errorf("part %d has title %q, want %q", i, part.title, test.titles[i])
+				}
+				text.WriteString(tagRe.ReplaceAllString(part.body, ""))
+			}
+
+			// No text is lost or repeated
+			if text.String() != tagRe.ReplaceAllString(test.body, "") {
+				t.Errorf("text of the parts differs from the body")
+			}
+		})
+	}
+}
+
+func TestFixSplitLinks(t *testing.T) {
+	file := func(name string, data string) *archiveFile {
+		return &archiveFile{header: &zip.FileHeader{Name: name}, data: []byte(data)}
+	}
+	opf := file("EPUB/package.opf", "")
+	files := []*archiveFile{
+		opf,
+		file("EPUB/xhtml/ch1.xhtml", `<h2 id="top">Chapter</h2><a href="#note">1</a><a href="#top">top</a>`),
+		file("EPUB/xhtml/ch1_2.xhtml", `<p id="note">Note</p><a href="#top">back</a><a href="#note">here</a>`),
+		file("EPUB/xhtml/other.xhtml", `<a href="ch1.xhtml#note">note</a><a href="ch1.xhtml#top">top</a><a href="ch1.xhtml">chapter</a>`),
+		file("EPUB/images/ch1.xhtml", `<a href="#note">not a section</a>`),
+	}
+	g := &Generator{splits: [][]string{{"ch1.xhtml", "ch1_2.xhtml"}}}
+	g.fixSplitLinks(files, opf)
+
+	want := []string{
+		"",
+		`<h2 id="top">Chapter</h2><a href="ch1_2.xhtml#note">1</a><a href="#top">top</a>`,
+		`<p id="note">Note</p><a href="ch1.xhtml#top">back</a><a href="#note">here</a>`,
+		`<a href="ch1_2.xhtml#note">note</a><a href="ch1.xhtml#top">top</a><a href="ch1.xhtml">chapter</a>`,
+		`<a href="#note">not a section</a>`,
+	}
+	for i, f := range files {
+		if string(f.data) != want[i] {
+			t.Errorf("%s:\ngot  %s\nwant %s", f.header.Name, f.data, want[i])
+		}
+	}
+
+	// Nothing changes without split sections
+	before := string(files[3].data)
+	(&Generator{}).fixSplitLinks(files, opf)
+	if string(files[3].data) != before {
+		t.Errorf("links changed without split sections")
+	}
+}
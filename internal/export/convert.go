@@ -0,0 +1,315 @@
+package export
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// markdownEscaper escapes the characters with a meaning in Markdown text
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`, "<", `\<`,
+)
+
+// converter renders section markup as Markdown or plain text
+type converter struct {
+	markdown bool
+
+	// image returns the path written for an image referenced by a section
+	image func(src string) string
+
+	// open holds the Markdown markers of the enclosing emphasis, which can't be nested
+	open map[string]bool
+}
+
+// textWriter accumulates converted text, collapsing whitespace like a browser
+type textWriter struct {
+	b     strings.Builder
+	space bool
+}
+
+// write appends inline output, preceded by a pending space unless at the start of a line
+func (w *textWriter) write(s string) {
+	if s == "" {
+		return
+	}
+	if w.space && !w.atLineStart() {
+		w.b.WriteByte(' ')
+	}
+	w.space = false
+	w.b.WriteString(s)
+}
+
+// text appends text with its whitespace collapsed
+func (w *textWriter) text(s string) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		if s != "" {
+			w.space = true
+		}
+		return
+	}
+	if s[0] == ' ' || s[0] == '\n' || s[0] == '\t' || s[0] == '\r' {
+		w.space = true
+	}
+	w.write(strings.Join(fields, " "))
+	if last := s[len(s)-1]; last == ' ' || last == '\n' || last == '\t' || last == '\r' {
+		w.space = true
+	}
+}
+
+// atLineStart returns true if nothing but line breaks were written since the last line
+func (w *textWriter) atLineStart() bool {
+	s := w.b.String()
+	return s == "" || strings.HasSuffix(s, "\n")
+}
+
+// lineBreak ends the current line
+func (w *textWriter) lineBreak() {
+	w.space = false
+	if !w.atLineStart() {
+		w.b.WriteString("\n")
+	}
+}
+
+// paragraph ends the current paragraph with a blank line
+func (w *textWriter) paragraph() {
+	w.space = false
+	s := w.b.String()
+	switch {
+	case s == "", strings.HasSuffix(s, "\n\n"):
+	case strings.HasSuffix(s, "\n"):
+		w.b.WriteString("\n")
+	default:
+		w.b.WriteString("\n\n")
+	}
+}
+
+// convert renders section markup and returns the text with a single trailing line break
+func (c *converter) convert(body string) (string, error) {
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(body), context)
+	if err != nil {
+		return "", fmt.Errorf("error parsing section: %v", err)
+	}
+
+	var w textWriter
+	for _, n := range nodes {
+		c.render(&w, n)
+	}
+	return strings.TrimSpace(w.b.String()) + "\n", nil
+}
+
+// renderChildren renders the children of a node
+func (c *converter) renderChildren(w *textWriter, n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.render(w, child)
+	}
+}
+
+// render renders a node and its children
+func (c *converter) render(w *textWriter, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		if c.markdown {
+			w.text(markdownEscaper.Replace(n.Data))
+		} else {
+			w.text(n.Data)
+		}
+		return
+	case html.ElementNode:
+	default:
+		c.renderChildren(w, n)
+		return
+	}
+
+	switch n.Data {
+	case "script", "style", "title":
+	case "p", "div", "section", "article", "header", "footer", "figure", "dl", "table", "tr":
+		w.paragraph()
+		c.renderChildren(w, n)
+		w.paragraph()
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		w.paragraph()
+		heading := c.inline(n)
+		if c.markdown {
+			level, _ := strconv.Atoi(n.Data[1:])
+			w.write(strings.Repeat("#", level) + " " + heading)
+		} else {
+			w.write(heading)
+			w.lineBreak()
+			underline := "-"
+			if n.Data == "h1" {
+				underline = "="
+			}
+			w.write(strings.Repeat(underline, len([]rune(heading))))
+		}
+		w.paragraph()
+	case "br":
+		if c.markdown {
+			w.b.WriteString("  ")
+		}
+		w.lineBreak()
+	case "hr":
+		w.paragraph()
+		w.write("* * *")
+		w.paragraph()
+	case "em", "i", "cite":
+		c.emphasis(w, n, "*")
+	case "strong", "b":
+		c.emphasis(w, n, "**")
+	case "s", "del", "strike":
+		c.emphasis(w, n, "~~")
+	case "a":
+		href := attribute(n, "href")
+		if c.markdown && isExternal(href) {
+			w.write("[" + c.inline(n) + "](" + href + ")")
+		} else {
+			c.renderChildren(w, n)
+		}
+	case "img":
+		c.writeImage(w, attribute(n, "src"), attribute(n, "alt"))
+	case "svg":
+		// Full-page illustrations are an image wrapped in an SVG
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.ElementNode && child.Data == "image" {
+				alt := ""
+				if title := findElement(n, "title"); title != nil && title.FirstChild != nil {
+					alt = title.FirstChild.Data
+				}
+				c.writeImage(w, attribute(child, "xlink:href"), alt)
+			}
+		}
+	case "figcaption":
+		w.paragraph()
+		c.emphasis(w, n, "*")
+		w.paragraph()
+	case "dt":
+		w.paragraph()
+		c.emphasis(w, n, "**")
+		if c.markdown {
+			w.b.WriteString("  ")
+		}
+		w.lineBreak()
+	case "dd":
+		c.renderChildren(w, n)
+		w.paragraph()
+	case "ul", "ol":
+		w.paragraph()
+		index := 0
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode || child.Data != "li" {
+				continue
+			}
+			index++
+			marker := "- "
+			if n.Data == "ol" {
+				marker = strconv.Itoa(index) + ". "
+			}
+			w.lineBreak()
+			w.write(marker + c.inline(child))
+		}
+		w.paragraph()
+	case "blockquote":
+		w.paragraph()
+		var quote textWriter
+		c.renderChildren(&quote, n)
+		prefix := "    "
+		if c.markdown {
+			prefix = "> "
+		}
+		for _, line := range strings.Split(strings.TrimSpace(quote.b.String()), "\n") {
+			w.b.WriteString(strings.TrimRight(prefix+line, " ") + "\n")
+		}
+		w.paragraph()
+	case "td", "th":
+		c.renderChildren(w, n)
+		w.space = true
+	default:
+		c.renderChildren(w, n)
+	}
+}
+
+// inline renders the children of a node on a single line
+func (c *converter) inline(n *html.Node) string {
+	var w textWriter
+	c.renderChildren(&w, n)
+	return strings.Join(strings.Fields(w.b.String()), " ")
+}
+
+// emphasis renders the children of a node between Markdown markers, or as is in plain text
+func (c *converter) emphasis(w *textWriter, n *html.Node, marker string) {
+	if !c.markdown || c.open[marker] {
+		c.renderChildren(w, n)
+		return
+	}
+	if c.open == nil {
+		c.open = make(map[string]bool)
+	}
+	c.open[marker] = true
+	defer delete(c.open, marker)
+
+	// Markers must touch the text, so surrounding spaces are moved outside
+	var inner textWriter
+	c.renderChildren(&inner, n)
+	text := inner.b.String()
+	if strings.TrimSpace(text) == "" {
+		w.space = w.space || inner.space || text != ""
+		return
+	}
+	if strings.TrimLeft(text, " \n") != text {
+		w.space = true
+	}
+	w.write(marker + strings.TrimSpace(text) + marker)
+	if inner.space || strings.TrimRight(text, " \n") != text {
+		w.space = true
+	}
+}
+
+// writeImage renders an image as a Markdown image or a plain text placeholder
+func (c *converter) writeImage(w *textWriter, src string, alt string) {
+	if c.markdown {
+		w.write("![" + markdownEscaper.Replace(alt) + "](" + c.image(src) + ")")
+		return
+	}
+	if alt != "" {
+		w.write("[Illustration: " + alt + "]")
+	} else {
+		w.write("[Illustration]")
+	}
+}
+
+// attribute returns the value of an attribute of a node, or an empty string
+func attribute(n *html.Node, name string) string {
+	for _, attr := range n.Attr {
+		key := attr.Key
+		if attr.Namespace != "" {
+			key = attr.Namespace + ":" + attr.Key
+		}
+		if key == name {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// findElement returns the first descendant element with the given name, or nil
+func findElement(n *html.Node, name string) *html.Node {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.Data == name {
+			return child
+		}
+		if found := findElement(child, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// isExternal returns true for links leaving the book
+func isExternal(href string) bool {
+	return strings.HasPrefix(href, "http://") || strings.HasPrefix(href, "https://") || strings.HasPrefix(href, "mailto:")
+}
@@ -0,0 +1,487 @@
+package export
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofrs/uuid"
+	"github.com/ynsta/seireitranslations-epub/internal/book"
+	"github.com/ynsta/seireitranslations-epub/internal/imaging"
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// fb2Genre is the genre of every book, FB2 requiring one from a fixed list
+const fb2Genre = "sf_fantasy"
+
+// fb2SceneBreak is the subtitle written for scene breaks
+const fb2SceneBreak = "* * *"
+
+// fb2TagRe matches the inline elements of a converted paragraph
+var fb2TagRe = regexp.MustCompile(`<[^>]*>`)
+
+// fb2Writer converts the sections of a book to FictionBook 2 markup
+type fb2Writer struct {
+	// images maps the paths used by the sections to binary IDs
+	images map[string]string
+
+	// used holds the IDs of the binaries referenced by the book
+	used map[string]bool
+}
+
+// fb2Paragraph accumulates the inline content of a paragraph being converted
+//
+// Line breaks and images end the paragraph, so the inline elements open at that
+// point are closed and reopened in the next one.
+type fb2Paragraph struct {
+	out    *strings.Builder
+	text   strings.Builder
+	open   []string
+	prefix string
+}
+
+// WriteFB2 writes a book as a FictionBook 2 file
+//
+// Sections become FB2 sections, headings inside them subtitles, and images are
+// embedded as base64 binaries. A random document ID is used if none is given.
+func WriteFB2(b *book.Book, filename string, identifier string) error {
+	w := &fb2Writer{images: make(map[string]string), used: make(map[string]bool)}
+
+	// Read the cover and images first, to know the IDs of their binaries
+	var images []book.Image
+	data := make(map[string][]byte)
+	for _, img := range append([]book.Image{b.Cover}, b.Images...) {
+		if img.Path == "" || data[img.Name] != nil {
+			continue
+		}
+		imgData, err := os.ReadFile(img.Path)
+		if err != nil {
+			return fmt.Errorf("error reading image %s: %v", img.Name, err)
+		}
+		w.images[book.ImagePath(img.Name)] = img.Name
+		data[img.Name] = imgData
+		images = append(images, img)
+	}
+
+	if identifier == "" {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return fmt.Errorf("error generating FB2 document ID: %v", err)
+		}
+		identifier = id.String()
+	}
+
+	var out strings.Builder
+	out.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+`)
+	w.writeDescription(&out, b, identifier)
+
+	out.WriteString("<body>\n")
+	fmt.Fprintf(&out, "<title><p>%s</p></title>\n", html.EscapeString(b.Title))
+	sections := b.ReadingOrder()
+	depth := 0
+	for i, section := range sections {
+		content, err := w.convert(section.Body)
+		if err != nil {
+			return fmt.Errorf("error converting %s: %v", section.Title, err)
+		}
+
+		// Nested sections are written inside the section of their parent
+		level := min(section.Level, depth)
+		for ; depth > level; depth-- {
+			out.WriteString("</section>\n")
+		}
+		out.WriteString("<section>\n")
+		if section.Title != "" {
+			fmt.Fprintf(&out, "<title><p>%s</p></title>\n", html.EscapeString(section.Title))
+		}
+		if i+1 < len(sections) && sections[i+1].Level > level {
+			// A section with subsections can only hold an image before them
+			content = strings.TrimSuffix(content, "<empty-line/>\n")
+			switch {
+			case content == "":
+			case strings.HasPrefix(content, "<image ") && strings.Count(content, "\n") == 1:
+				out.WriteString(content)
+			default:
+				out.WriteString("<section>\n" + content + "</section>\n")
+			}
+			depth++
+			continue
+		}
+		out.WriteString(content)
+		out.WriteString("</section>\n")
+	}
+	for ; depth > 0; depth-- {
+		out.WriteString("</section>\n")
+	}
+	out.WriteString("</body>\n")
+
+	// Only the referenced images are embedded, so images dropped from the sections add no weight
+	for _, img := range images {
+		if w.used[img.Name] {
+			fmt.Fprintf(&out, "<binary id=\"%s\" content-type=\"%s\">%s</binary>\n",
+				html.EscapeString(img.Name), imaging.Sniff(data[img.Name]).MediaType, base64.StdEncoding.EncodeToString(data[img.Name]))
+		}
+	}
+	out.WriteString("</FictionBook>\n")
+
+	if err := os.WriteFile(filename, []byte(out.String()), 0644); err != nil {
+		return fmt.Errorf("error writing FB2 file: %v", err)
+	}
+	return nil
+}
+
+// writeDescription writes the title, document and publishing information of a book
+func (w *fb2Writer) writeDescription(out *strings.Builder, b *book.Book, identifier string) {
+	m := b.Metadata
+
+	out.WriteString("<description>\n<title-info>\n")
+	fmt.Fprintf(out, "<genre>%s</genre>\n", fb2Genre)
+	fmt.Fprintf(out, "<author>%s</author>\n", fb2Person(b.Author))
+	fmt.Fprintf(out, "<book-title>%s</book-title>\n", html.EscapeString(b.Title))
+	if m.Description != "" {
+		fmt.Fprintf(out, "<annotation><p>%s</p></annotation>\n", html.EscapeString(m.Description))
+	}
+	if len(m.Subjects) > 0 {
+		fmt.Fprintf(out, "<keywords>%s</keywords>\n", html.EscapeString(strings.Join(m.Subjects, ", ")))
+	}
+	if !m.Date.IsZero() {
+		date := m.Date.Format(time.DateOnly)
+		fmt.Fprintf(out, "<date value=\"%s\">%s</date>\n", date, date)
+	}
+	if id, ok := w.images[book.ImagePath(b.Cover.Name)]; ok && b.Cover.Name != "" {
+		w.used[id] = true
+		fmt.Fprintf(out, "<coverpage><image l:href=\"#%s\"/></coverpage>\n", html.EscapeString(id))
+	}
+	fmt.Fprintf(out, "<lang>%s</lang>\n", html.EscapeString(language(b)))
+	for _, name := range m.ContributorsWithRole(book.RoleTranslator) {
+		fmt.Fprintf(out, "<translator>%s</translator>\n", fb2Person(name))
+	}
+	if m.Series != "" {
+		out.WriteString(fb2Sequence(m.Series, m.SeriesIndex))
+	}
+	out.WriteString("</title-info>\n")
+
+	out.WriteString("<document-info>\n")
+	out.WriteString("<author><nickname>seireitranslations-epub</nickname></author>\n")
+	out.WriteString("<program-used>seireitranslations-epub</program-used>\n")
+	date := m.Date
+	if !m.Revised.IsZero() {
+		date = m.Revised
+	} else if date.IsZero() {
+		date = time.Now()
+	}
+	fmt.Fprintf(out, "<date value=\"%s\">%s</date>\n", date.Format(time.DateOnly), date.Format(time.DateOnly))
+	fmt.Fprintf(out, "<id>%s</id>\n", html.EscapeString(strings.TrimPrefix(identifier, "urn:uuid:")))
+	fmt.Fprintf(out, "<version>%d.0</version>\n", max(1, m.Revision))
+	out.WriteString("</document-info>\n")
+
+	if m.Publisher != "" || m.Series != "" {
+		out.WriteString("<publish-info>\n")
+		if m.Publisher != "" {
+			fmt.Fprintf(out, "<publisher>%s</publisher>\n", html.EscapeString(m.Publisher))
+		}
+		if !m.Date.IsZero() {
+			fmt.Fprintf(out, "<year>%d</year>\n", m.Date.Year())
+		}
+		if m.Series != "" {
+			out.WriteString(fb2Sequence(m.Series, m.SeriesIndex))
+		}
+		out.WriteString("</publish-info>\n")
+	}
+
+	out.WriteString("</description>\n")
+}
+
+// fb2Person renders the name elements of an author or translator
+//
+// Single names are written as nicknames, other names are split into a first
+// and a last name.
+func fb2Person(name string) string {
+	parts := strings.Fields(name)
+	switch len(parts) {
+	case 0:
+		return "<nickname>Unknown</nickname>"
+	case 1:
+		return "<nickname>" + html.EscapeString(parts[0]) + "</nickname>"
+	}
+	return "<first-name>" + html.EscapeString(parts[0]) + "</first-name><last-name>" +
+		html.EscapeString(strings.Join(parts[1:], " ")) + "</last-name>"
+}
+
+// fb2Sequence renders a series, with its number if it is a whole number as FB2 requires
+func fb2Sequence(series string, index string) string {
+	if number, err := strconv.Atoi(index); err == nil {
+		return fmt.Sprintf("<sequence name=\"%s\" number=\"%d\"/>\n", html.EscapeString(series), number)
+	}
+	return fmt.Sprintf("<sequence name=\"%s\"/>\n", html.EscapeString(series))
+}
+
+// convert converts the body of a section to FB2 section content
+//
+// The leading heading is dropped since it repeats the section title. Sections
+// with nothing but an image get an empty line, as FB2 sections need content.
+func (w *fb2Writer) convert(body string) (string, error) {
+	context := &xhtml.Node{Type: xhtml.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := xhtml.ParseFragment(strings.NewReader(body), context)
+	if err != nil {
+		return "", fmt.Errorf("error parsing section: %v", err)
+	}
+
+	var out strings.Builder
+	leading := true
+	for _, n := range nodes {
+		w.block(&out, n, &leading)
+	}
+	content := out.String()
+	if content == "" || (strings.HasPrefix(content, "<image ") && strings.Count(content, "\n") == 1) {
+		content += "<empty-line/>\n"
+	}
+	return content, nil
+}
+
+// block converts a node in a block context
+//
+// leading is true until the first content is written, to drop the leading heading.
+func (w *fb2Writer) block(out *strings.Builder, n *xhtml.Node, leading *bool) {
+	switch n.Type {
+	case xhtml.TextNode:
+		if text := strings.Join(strings.Fields(n.Data), " "); text != "" {
+			fmt.Fprintf(out, "<p>%s</p>\n", html.EscapeString(text))
+			*leading = false
+		}
+		return
+	case xhtml.ElementNode:
+	default:
+		w.children(out, n, leading)
+		return
+	}
+
+	before := out.Len()
+	switch n.Data {
+	case "script", "style", "title":
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		if *leading {
+			*leading = false
+			return
+		}
+		p := &fb2Paragraph{out: out}
+		w.inlineChildren(p, n)
+		if text := p.text.String(); strings.TrimSpace(text) != "" {
+			fmt.Fprintf(out, "<subtitle>%s</subtitle>\n", strings.TrimSpace(text))
+		}
+	case "p", "dd", "td", "th":
+		w.paragraph(out, n, "", "")
+	case "dt":
+		w.paragraph(out, n, "", "strong")
+	case "figcaption":
+		w.paragraph(out, n, "", "emphasis")
+	case "hr":
+		fmt.Fprintf(out, "<subtitle>%s</subtitle>\n", fb2SceneBreak)
+	case "img":
+		w.image(out, attribute(n, "src"), attribute(n, "alt"))
+	case "svg":
+		if image := findElement(n, "image"); image != nil {
+			alt := ""
+			if title := findElement(n, "title"); title != nil && title.FirstChild != nil {
+				alt = title.FirstChild.Data
+			}
+			w.image(out, attribute(image, "xlink:href"), alt)
+		}
+	case "ul", "ol":
+		index := 0
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != xhtml.ElementNode || child.Data != "li" {
+				continue
+			}
+			index++
+			prefix := "• "
+			if n.Data == "ol" {
+				prefix = strconv.Itoa(index) + ". "
+			}
+			w.paragraph(out, child, prefix, "")
+		}
+	case "blockquote":
+		var quote strings.Builder
+		quoteLeading := false
+		w.children(&quote, n, &quoteLeading)
+		if quote.Len() > 0 {
+			out.WriteString("<cite>\n" + quote.String() + "</cite>\n")
+		}
+	default:
+		w.children(out, n, leading)
+		return
+	}
+	if out.Len() > before {
+		*leading = false
+	}
+}
+
+// children converts the children of a node in a block context
+func (w *fb2Writer) children(out *strings.Builder, n *xhtml.Node, leading *bool) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		w.block(out, child, leading)
+	}
+}
+
+// paragraph converts a block of text to paragraphs, optionally prefixed and wrapped in an inline style
+//
+// Centered paragraphs without letters or digits, such as "◇ ◇ ◇", are scene
+// breaks and become subtitles.
+func (w *fb2Writer) paragraph(out *strings.Builder, n *xhtml.Node, prefix string, style string) {
+	if isSceneBreak(n) {
+		fmt.Fprintf(out, "<subtitle>%s</subtitle>\n", html.EscapeString(strings.Join(strings.Fields(textContent(n)), " ")))
+		return
+	}
+
+	p := &fb2Paragraph{out: out, prefix: prefix}
+	if style != "" {
+		p.openTag(style, "")
+	}
+	w.inlineChildren(p, n)
+	p.flush()
+}
+
+// inlineChildren converts the children of a node in an inline context
+func (w *fb2Writer) inlineChildren(p *fb2Paragraph, n *xhtml.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		w.inline(p, child)
+	}
+}
+
+// inline converts a node in an inline context
+func (w *fb2Writer) inline(p *fb2Paragraph, n *xhtml.Node) {
+	switch n.Type {
+	case xhtml.TextNode:
+		p.text.WriteString(html.EscapeString(n.Data))
+		return
+	case xhtml.ElementNode:
+	default:
+		w.inlineChildren(p, n)
+		return
+	}
+
+	tag, attrs := "", ""
+	switch n.Data {
+	case "script", "style":
+		return
+	case "br":
+		p.flush()
+		return
+	case "img":
+		// Images can't be inline without a binary, and read better as blocks
+		p.flush()
+		w.image(p.out, attribute(n, "src"), attribute(n, "alt"))
+		return
+	case "em", "i", "cite":
+		tag = "emphasis"
+	case "strong", "b":
+		tag = "strong"
+	case "s", "del", "strike":
+		tag = "strikethrough"
+	case "sup", "sub", "code":
+		tag = n.Data
+	case "a":
+		if href := attribute(n, "href"); isExternal(href) {
+			tag, attrs = "a", fmt.Sprintf(" l:href=\"%s\"", html.EscapeString(href))
+		}
+	}
+
+	if tag == "" {
+		w.inlineChildren(p, n)
+		return
+	}
+	p.openTag(tag, attrs)
+	w.inlineChildren(p, n)
+	p.closeTag()
+}
+
+// image writes a block image referencing the binary of an image
+func (w *fb2Writer) image(out *strings.Builder, src string, alt string) {
+	id, ok := w.images[src]
+	if !ok {
+		return
+	}
+	w.used[id] = true
+	if alt != "" {
+		fmt.Fprintf(out, "<image l:href=\"#%s\" alt=\"%s\"/>\n", html.EscapeString(id), html.EscapeString(alt))
+	} else {
+		fmt.Fprintf(out, "<image l:href=\"#%s\"/>\n", html.EscapeString(id))
+	}
+}
+
+// openTag opens an inline element
+func (p *fb2Paragraph) openTag(tag string, attrs string) {
+	p.open = append(p.open, "<"+tag+attrs+">")
+	p.text.WriteString("<" + tag + attrs + ">")
+}
+
+// closeTag closes the innermost inline element
+func (p *fb2Paragraph) closeTag() {
+	open := p.open[len(p.open)-1]
+	p.open = p.open[:len(p.open)-1]
+	p.text.WriteString("</" + tagName(open) + ">")
+}
+
+// flush writes the paragraph if it has text, and starts the next one with the same open elements
+func (p *fb2Paragraph) flush() {
+	for i := len(p.open) - 1; i >= 0; i-- {
+		p.text.WriteString("</" + tagName(p.open[i]) + ">")
+	}
+	text := strings.Join(strings.Fields(p.text.String()), " ")
+	if strings.TrimSpace(fb2TagRe.ReplaceAllString(text, "")) != "" {
+		p.out.WriteString("<p>" + html.EscapeString(p.prefix) + text + "</p>\n")
+		p.prefix = ""
+	}
+
+	p.text.Reset()
+	for _, open := range p.open {
+		p.text.WriteString(open)
+	}
+}
+
+// tagName returns the name of an element from its start tag
+func tagName(startTag string) string {
+	name := strings.TrimPrefix(startTag, "<")
+	if i := strings.IndexAny(name, " >"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// isSceneBreak returns true for centered paragraphs made only of symbols
+func isSceneBreak(n *xhtml.Node) bool {
+	if !strings.Contains(" "+attribute(n, "class")+" ", " center ") {
+		return false
+	}
+	text := strings.TrimSpace(textContent(n))
+	if text == "" {
+		return false
+	}
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// textContent returns the text of a node and its descendants
+func textContent(n *xhtml.Node) string {
+	if n.Type == xhtml.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(textContent(child))
+	}
+	return b.String()
+}
@@ -0,0 +1,205 @@
+package export
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ynsta/seireitranslations-epub/internal/book"
+	"github.com/ynsta/seireitranslations-epub/pkg/utils"
+)
+
+// xmlNode is a generic XML element, to check the structure of a document
+type xmlNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Children []xmlNode  `xml:",any"`
+}
+
+// fb2TitleInfoOrder lists the children of title-info in the order of the FB2 schema
+var fb2TitleInfoOrder = []string{
+	"genre", "author", "book-title", "annotation", "keywords", "date",
+	"coverpage", "lang", "src-lang", "translator", "sequence",
+}
+
+func TestWriteFB2Structure(t *testing.T) {
+	dir := t.TempDir()
+	b := book.New(book.Config{
+		Title:   "Test Book",
+		Author:  "Some Author",
+		TempDir: dir,
+		Metadata: book.Metadata{
+			Language:     "en",
+			Description:  "A test book.",
+			Date:         time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			Subjects:     []string{"Fantasy", "Light Novel"},
+			Series:       "Test Series",
+			SeriesIndex:  "2",
+			Contributors: []book.Contributor{{Name: "Translator", Role: book.RoleTranslator}},
+		},
+	})
+	if err := b.SetCover(testPNG(t), "test cover"); err != nil {
+		t.Fatal(err)
+	}
+	illustration := b.AddImage(writeTestPNG(t, dir, "illustration.png"), "illustration.png")
+	b.AddImage(writeTestPNG(t, dir, "unused.png"), "unused.png")
+
+	b.StartVolume("Volume 1", utils.Illustration{Src: illustration, Alt: "Volume cover"})
+	if err := b.AddChapter("Chapter 1", `<section class="chapter" epub:type="chapter">
+<h2>Chapter 1</h2>
+<p>Some <em>text</em> with an image<br/><img src="`+illustration+`" alt="Illustration"/> inside.</p>
+<h3>Part 2</h3>
+<p>More text.</p>
+</section>`); err != nil {
+		t.Fatal(err)
+	}
+	b.EndVolume()
+	if err := b.AddGlossaryChapter("Glossary", "glossary.xhtml", []utils.GlossaryEntry{
+		{Term: "Mana", Definition: "Magical energy."},
+		{Term: "Senpai", Aliases: []string{"-senpai"}, Definition: "An upperclassman."},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(dir, "book.fb2")
+	if err := WriteFB2(b, filename, "urn:uuid:0b5d6a3c-0000-4000-8000-000000000000"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var root xmlNode
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = true
+	if err := decoder.Decode(&root); err != nil {
+		t.Fatalf("FB2 is not well-formed: %v", err)
+	}
+
+	// The children of title-info follow the order of the schema
+	titleInfo := findNode(&root, "title-info")
+	if titleInfo == nil {
+		t.Fatal("no title-info element")
+	}
+	position := 0
+	for _, child := range titleInfo.Children {
+		for position < len(fb2TitleInfoOrder) && fb2TitleInfoOrder[position] != child.XMLName.Local {
+			position++
+		}
+		if position == len(fb2TitleInfoOrder) {
+			t.Fatalf("title-info child %s is unknown or out of schema order", child.XMLName.Local)
+		}
+	}
+
+	// Every image references a binary, and every binary is referenced
+	references := make(map[string]bool)
+	walkNodes(&root, func(n *xmlNode) {
+		if n.XMLName.Local == "image" {
+			references[attrValue(n, "href")] = true
+		}
+	})
+	binaries := make(map[string]bool)
+	for _, child := range root.Children {
+		if child.XMLName.Local == "binary" {
+			id := attrValue(&child, "id")
+			if binaries[id] {
+				t.Errorf("duplicate binary %q", id)
+			}
+			binaries[id] = true
+			if !references["#"+id] {
+				t.Errorf("binary %q is not referenced", id)
+			}
+		}
+	}
+	for href := range references {
+		if len(href) < 2 || href[0] != '#' || !binaries[href[1:]] {
+			t.Errorf("image %q has no matching binary", href)
+		}
+	}
+	if len(references) == 0 {
+		t.Error("no image written")
+	}
+
+	// Sections hold either subsections or content, never both
+	sections := 0
+	walkNodes(&root, func(n *xmlNode) {
+		if n.XMLName.Local != "section" {
+			return
+		}
+		sections++
+		hasSections, hasContent := false, false
+		for _, child := range n.Children {
+			switch child.XMLName.Local {
+			case "section":
+				hasSections = true
+			case "p", "subtitle", "empty-line", "poem", "cite", "table":
+				hasContent = true
+			}
+		}
+		if hasSections && hasContent {
+			t.Errorf("section %d mixes subsections and content", sections)
+		}
+	})
+	if sections == 0 {
+		t.Error("no section written")
+	}
+}
+
+// findNode returns the first element with the given name, or nil
+func findNode(n *xmlNode, name string) *xmlNode {
+	if n.XMLName.Local == name {
+		return n
+	}
+	for i := range n.Children {
+		if found := findNode(&n.Children[i], name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// walkNodes calls fn for an element and all its descendants
+func walkNodes(n *xmlNode, fn func(*xmlNode)) {
+	fn(n)
+	for i := range n.Children {
+		walkNodes(&n.Children[i], fn)
+	}
+}
+
+// attrValue returns the value of an attribute by local name, whatever its namespace
+func attrValue(n *xmlNode, name string) string {
+	for _, attr := range n.Attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// testPNG returns a small PNG image
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 6))
+	img.Set(1, 1, color.RGBA{200, 10, 10, 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeTestPNG writes a small PNG image and returns its path
+func writeTestPNG(t *testing.T, dir string, name string) string {
+	t.Helper()
+	filename := filepath.Join(dir, name)
+	if err := os.WriteFile(filename, testPNG(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
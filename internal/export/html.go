@@ -0,0 +1,165 @@
+package export
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/ynsta/seireitranslations-epub/internal/book"
+	"github.com/ynsta/seireitranslations-epub/internal/imaging"
+)
+
+// epubTypeRe matches the epub:type attributes, which have no meaning outside an EPUB
+var epubTypeRe = regexp.MustCompile(` epub:type="[^"]*"`)
+
+// htmlIDRe captures the id attributes of a section
+var htmlIDRe = regexp.MustCompile(`(\sid=")([^"]+)"`)
+
+// htmlHrefRe captures the link targets of a section
+var htmlHrefRe = regexp.MustCompile(`(\shref=")([^"]*)"`)
+
+// WriteHTML writes a book as a single self-contained HTML file
+//
+// The stylesheet is inlined and every image is embedded as a data: URL, so the
+// file can be published or opened in a browser on its own.
+func WriteHTML(b *book.Book, filename string) error {
+	images, err := dataURLs(b)
+	if err != nil {
+		return err
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, `<!DOCTYPE html>
+<html lang="%s">
+<head>
+<meta charset="utf-8"/>
+<meta name="viewport" content="width=device-width, initial-scale=1"/>
+<title>%s</title>
+<meta name="author" content="%s"/>
+`, html.EscapeString(language(b)), html.EscapeString(b.Title), html.EscapeString(b.Author))
+	if len(b.Stylesheet) > 0 {
+		fmt.Fprintf(&out, "<style>\n%s\n</style>\n", b.Stylesheet)
+	}
+	out.WriteString("</head>\n<body>\n")
+
+	// Title page, with the cover if there is one
+	out.WriteString("<header class=\"title-page\">\n")
+	if src, ok := images[book.ImagePath(b.Cover.Name)]; ok && b.Cover.Name != "" {
+		fmt.Fprintf(&out, "<p><img src=\"%s\" alt=\"Cover\" style=\"max-width: 100%%; height: auto;\"/></p>\n", src)
+	}
+	fmt.Fprintf(&out, "<h1>%s</h1>\n<p>%s</p>\n</header>\n", html.EscapeString(b.Title), html.EscapeString(b.Author))
+
+	sections := b.ReadingOrder()
+
+	// Table of contents linking to every titled section
+	out.WriteString("<nav class=\"toc\">\n<h2>Contents</h2>\n<ol>\n")
+	depth := -1
+	for i, section := range sections {
+		if section.Title == "" {
+			continue
+		}
+		// Nested sections open a list inside the item of their parent
+		level := min(section.Level, depth+1)
+		switch {
+		case depth < 0:
+			level = 0
+		case level > depth:
+			out.WriteString("\n<ol>\n")
+		default:
+			out.WriteString("</li>\n")
+			for ; depth > level; depth-- {
+				out.WriteString("</ol>\n</li>\n")
+			}
+		}
+		depth = level
+		fmt.Fprintf(&out, "<li><a href=\"#section-%d\">%s</a>", i+1, html.EscapeString(section.Title))
+	}
+	if depth >= 0 {
+		out.WriteString("</li>\n")
+	}
+	for ; depth > 0; depth-- {
+		out.WriteString("</ol>\n</li>\n")
+	}
+	out.WriteString("</ol>\n</nav>\n")
+
+	for i, section := range sections {
+		body := epubTypeRe.ReplaceAllString(section.Body, "")
+		body = localLinks(body, i, sections)
+		for old, src := range images {
+			body = strings.ReplaceAll(body, old, src)
+		}
+		fmt.Fprintf(&out, "<section id=\"section-%d\">\n%s\n</section>\n", i+1, body)
+	}
+	out.WriteString("</body>\n</html>\n")
+
+	if err := os.WriteFile(filename, []byte(out.String()), 0644); err != nil {
+		return fmt.Errorf("error writing HTML file: %v", err)
+	}
+	return nil
+}
+
+// dataURLs reads the cover and images of a book and returns their data: URLs by section path
+func dataURLs(b *book.Book) (map[string]string, error) {
+	images := make(map[string]string)
+	for _, img := range append([]book.Image{b.Cover}, b.Images...) {
+		if img.Path == "" {
+			continue
+		}
+		data, err := os.ReadFile(img.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading image %s: %v", img.Name, err)
+		}
+		images[book.ImagePath(img.Name)] = "data:" + imaging.Sniff(data).MediaType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	}
+	return images, nil
+}
+
+// localLinks makes the ids of a section unique in the single document and points
+// links within and between sections to them
+//
+// Every section gets its own id prefix, as each one was a separate file in which
+// the same ids, like those of the Readability pages, may appear.
+func localLinks(body string, index int, sections []book.Section) string {
+	body = htmlIDRe.ReplaceAllString(body, "${1}"+sectionPrefix(index)+`${2}"`)
+
+	return htmlHrefRe.ReplaceAllStringFunc(body, func(match string) string {
+		parts := htmlHrefRe.FindStringSubmatch(match)
+		if parts[2] == "" {
+			return match
+		}
+		file, fragment, _ := strings.Cut(parts[2], "#")
+		target := index
+		if file != "" {
+			target = -1
+			for i, section := range sections {
+				if section.Filename != "" && section.Filename == file {
+					target = i
+					break
+				}
+			}
+			if target < 0 {
+				return match
+			}
+		}
+		if fragment == "" {
+			return fmt.Sprintf(`%s#section-%d"`, parts[1], target+1)
+		}
+		return parts[1] + "#" + sectionPrefix(target) + fragment + `"`
+	})
+}
+
+// sectionPrefix returns the prefix of the ids of a section in the single document
+func sectionPrefix(index int) string {
+	return fmt.Sprintf("s%d-", index+1)
+}
+
+// language returns the language of a book, English if unknown
+func language(b *book.Book) string {
+	if b.Metadata.Language != "" {
+		return b.Metadata.Language
+	}
+	return "en"
+}
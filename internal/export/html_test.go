@@ -0,0 +1,73 @@
+package export
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ynsta/seireitranslations-epub/internal/book"
+)
+
+func TestWriteHTMLUniqueIDs(t *testing.T) {
+	dir := t.TempDir()
+	b := book.New(book.Config{Title: "Test Book", Author: "Some Author", TempDir: dir})
+	chapter := func(title string) string {
+		return `<section class="chapter" epub:type="chapter"><div id="readability-page-1" class="page">
+<h2>` + title + `</h2>
+<p>See the <a href="#note">note</a>, <a href="glossary.xhtml#term-mana">mana</a> and the <a href="glossary.xhtml">glossary</a>.</p>
+<p id="note">A note of ` + title + `.</p>
+<p><a href="https://example.com/page#note">Source</a> <a href="">empty</a></p>
+</div></section>`
+	}
+	b.Sections = []book.Section{
+		{Title: "Chapter 1", Type: book.TypeBodymatter, Body: chapter("Chapter 1")},
+		{Title: "Chapter 2", Type: book.TypeBodymatter, Body: chapter("Chapter 2")},
+		{Title: "Glossary", Filename: "glossary.xhtml", Type: book.TypeBackmatter,
+			Body: `<section epub:type="glossary"><h2>Glossary</h2><dl><dt id="term-mana">Mana</dt><dd>Magical energy.</dd></dl></section>`},
+	}
+
+	filename := filepath.Join(dir, "book.html")
+	if err := WriteHTML(b, filename); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+
+	// Every id appears once
+	ids := make(map[string]bool)
+	for _, match := range regexp.MustCompile(`\sid="([^"]+)"`).FindAllStringSubmatch(out, -1) {
+		if ids[match[1]] {
+			t.Errorf("duplicate id %q", match[1])
+		}
+		ids[match[1]] = true
+	}
+
+	// Every fragment link points to an id of the document
+	for _, match := range regexp.MustCompile(`\shref="#([^"]*)"`).FindAllStringSubmatch(out, -1) {
+		if !ids[match[1]] {
+			t.Errorf("link to missing id %q", match[1])
+		}
+	}
+
+	for _, want := range []string{
+		`<div id="s1-readability-page-1" class="page">`,
+		`<div id="s2-readability-page-1" class="page">`,
+		`<a href="#s2-note">note</a>`,
+		`<a href="#s3-term-mana">mana</a>`,
+		`<a href="#section-3">glossary</a>`,
+		`<a href="https://example.com/page#note">Source</a>`,
+		`<a href="">empty</a>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %s", want)
+		}
+	}
+	if strings.Contains(out, "epub:type") {
+		t.Error("output keeps epub:type attributes")
+	}
+}
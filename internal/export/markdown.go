@@ -0,0 +1,82 @@
+package export
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ynsta/seireitranslations-epub/internal/book"
+)
+
+// slugRe matches the runs of characters replaced in section file names
+var slugRe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// WriteMarkdown writes a book as a folder of Markdown files, one per section
+//
+// The folder holds an index.md with the title page and table of contents, the
+// numbered section files and an images folder with the cover and images.
+func WriteMarkdown(b *book.Book, dir string) error {
+	if err := os.MkdirAll(filepath.Join(dir, book.ImageFolder), 0755); err != nil {
+		return fmt.Errorf("error creating Markdown folder: %v", err)
+	}
+
+	// Copy the images, which the sections reference relative to the folder
+	for _, img := range append([]book.Image{b.Cover}, b.Images...) {
+		if img.Path == "" {
+			continue
+		}
+		data, err := os.ReadFile(img.Path)
+		if err != nil {
+			return fmt.Errorf("error reading image %s: %v", img.Name, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, book.ImageFolder, img.Name), data, 0644); err != nil {
+			return fmt.Errorf("error writing image %s: %v", img.Name, err)
+		}
+	}
+
+	c := &converter{
+		markdown: true,
+		image: func(src string) string {
+			return strings.TrimPrefix(src, "../")
+		},
+	}
+
+	var index strings.Builder
+	fmt.Fprintf(&index, "# %s\n\n%s\n\n", markdownEscaper.Replace(b.Title), markdownEscaper.Replace(b.Author))
+	if b.Cover.Name != "" {
+		fmt.Fprintf(&index, "![Cover](%s)\n\n", path.Join(book.ImageFolder, b.Cover.Name))
+	}
+	index.WriteString("## Contents\n\n")
+
+	for i, section := range b.ReadingOrder() {
+		filename := sectionFilename(i+1, section.Title, ".md")
+		text, err := c.convert(section.Body)
+		if err != nil {
+			return fmt.Errorf("error converting %s: %v", filename, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, filename), []byte(text), 0644); err != nil {
+			return fmt.Errorf("error writing Markdown file %s: %v", filename, err)
+		}
+		if section.Title != "" {
+			indent := strings.Repeat("  ", section.Level)
+			fmt.Fprintf(&index, "%s- [%s](%s)\n", indent, markdownEscaper.Replace(section.Title), filename)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "index.md"), []byte(index.String()), 0644); err != nil {
+		return fmt.Errorf("error writing Markdown index: %v", err)
+	}
+	return nil
+}
+
+// sectionFilename names the file of a section after its position and title
+func sectionFilename(number int, title string, extension string) string {
+	slug := strings.Trim(slugRe.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if slug == "" {
+		slug = "illustration"
+	}
+	return fmt.Sprintf("%03d-%s%s", number, slug, extension)
+}
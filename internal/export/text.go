@@ -0,0 +1,36 @@
+package export
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ynsta/seireitranslations-epub/internal/book"
+)
+
+// sectionSeparator separates the sections of a plain text book
+const sectionSeparator = "\n\n\n"
+
+// WriteText writes a book as a single plain text file
+//
+// Formatting is dropped, headings are underlined and images are replaced by
+// their alt text, which makes the file easy to proofread and diff.
+func WriteText(b *book.Book, filename string) error {
+	c := &converter{}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "%s\n%s\n\n%s\n", b.Title, strings.Repeat("=", len([]rune(b.Title))), b.Author)
+	for _, section := range b.ReadingOrder() {
+		text, err := c.convert(section.Body)
+		if err != nil {
+			return fmt.Errorf("error converting %s: %v", section.Title, err)
+		}
+		out.WriteString(sectionSeparator)
+		out.WriteString(text)
+	}
+
+	if err := os.WriteFile(filename, []byte(out.String()), 0644); err != nil {
+		return fmt.Errorf("error writing text file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"log/slog"
+
+	"github.com/ynsta/seireitranslations-epub/internal/logger"
+)
+
+// Limits used when shrinking images to fit a size budget
+const (
+	budgetScale        = 0.75
+	budgetQuality      = 80
+	budgetMinDimension = 480
+)
+
+// BudgetImage is an image that can be shrunk to fit a size budget
+type BudgetImage struct {
+	Name      string
+	Data      []byte
+	Extension string
+
+	original int
+	fixed    bool
+	changed  bool
+}
+
+// BudgetChange describes how an image was changed to fit a size budget
+type BudgetChange struct {
+	Name         string
+	OriginalSize int
+	Size         int
+	Width        int
+	Height       int
+	Extension    string
+}
+
+// FitBudget shrinks the largest images until their total size fits the budget
+//
+// The largest image is repeatedly downscaled by a quarter and recompressed,
+// opaque images as JPEG and transparent ones as PNG, until the total fits or
+// no image can be shrunk any further. Vector images, animated GIFs and images
+// already smaller than 480 pixels are left alone. It returns the changed
+// images and whether the budget was met.
+func FitBudget(images []*BudgetImage, budget int64) ([]BudgetChange, bool) {
+	var total int64
+	for _, img := range images {
+		img.original = len(img.Data)
+		total += int64(len(img.Data))
+	}
+
+	for total > budget {
+		// Pick the largest image that can still be shrunk
+		var largest *BudgetImage
+		for _, img := range images {
+			if !img.fixed && (largest == nil || len(img.Data) > len(largest.Data)) {
+				largest = img
+			}
+		}
+		if largest == nil {
+			break
+		}
+
+		data, ext, err := shrink(largest.Data)
+		if err != nil || len(data) >= len(largest.Data) {
+			if err != nil && logger.Debug {
+				slog.Debug("Image cannot be shrunk further", "name", largest.Name, "reason", err)
+			}
+			largest.fixed = true
+			continue
+		}
+
+		total -= int64(len(largest.Data) - len(data))
+		largest.Data, largest.Extension, largest.changed = data, ext, true
+	}
+
+	var changes []BudgetChange
+	for _, img := range images {
+		if !img.changed {
+			continue
+		}
+		change := BudgetChange{
+			Name:         img.Name,
+			OriginalSize: img.original,
+			Size:         len(img.Data),
+			Extension:    img.Extension,
+		}
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(img.Data)); err == nil {
+			change.Width, change.Height = cfg.Width, cfg.Height
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, total <= budget
+}
+
+// shrink downscales an image by a quarter and recompresses it
+func shrink(data []byte) ([]byte, string, error) {
+	if Sniff(data) == FormatSVG {
+		return nil, "", fmt.Errorf("vector image")
+	}
+	if g, err := gif.DecodeAll(bytes.NewReader(data)); err == nil && len(g.Image) > 1 {
+		return nil, "", fmt.Errorf("animated image")
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("error decoding image: %v", err)
+	}
+
+	bounds := img.Bounds()
+	maxDimension := int(float64(max(bounds.Dx(), bounds.Dy())) * budgetScale)
+	if maxDimension < budgetMinDimension {
+		return nil, "", fmt.Errorf("image already at minimum size")
+	}
+	img = Resize(img, maxDimension)
+
+	var buf bytes.Buffer
+	if isOpaque(img) {
+		err = jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: budgetQuality})
+		return buf.Bytes(), FormatJPEG.Extension, err
+	}
+	err = png.Encode(&buf, img)
+	return buf.Bytes(), FormatPNG.Extension, err
+}
@@ -0,0 +1,129 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log/slog"
+
+	"github.com/ynsta/seireitranslations-epub/internal/logger"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// Format describes an image format detected from its content
+type Format struct {
+	MediaType string
+	Extension string
+	Core      bool
+}
+
+// Known image formats; core formats are the image media types every EPUB reader supports
+var (
+	FormatJPEG    = Format{MediaType: "image/jpeg", Extension: ".jpg", Core: true}
+	FormatPNG     = Format{MediaType: "image/png", Extension: ".png", Core: true}
+	FormatGIF     = Format{MediaType: "image/gif", Extension: ".gif", Core: true}
+	FormatSVG     = Format{MediaType: "image/svg+xml", Extension: ".svg", Core: true}
+	FormatWebP    = Format{MediaType: "image/webp", Extension: ".webp"}
+	FormatBMP     = Format{MediaType: "image/bmp", Extension: ".bmp"}
+	FormatTIFF    = Format{MediaType: "image/tiff", Extension: ".tiff"}
+	FormatAVIF    = Format{MediaType: "image/avif", Extension: ".avif"}
+	FormatUnknown = Format{MediaType: "application/octet-stream"}
+)
+
+// Sniff detects the image format from the first bytes of the data
+func Sniff(data []byte) Format {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return FormatJPEG
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return FormatPNG
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return FormatGIF
+	case len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return FormatWebP
+	case bytes.HasPrefix(data, []byte("BM")) && len(data) >= 26:
+		return FormatBMP
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return FormatTIFF
+	case len(data) >= 12 && bytes.Equal(data[4:8], []byte("ftyp")) &&
+		(bytes.Equal(data[8:12], []byte("avif")) || bytes.Equal(data[8:12], []byte("avis"))):
+		return FormatAVIF
+	}
+
+	// SVG is text, possibly preceded by an XML declaration or comments
+	head := data
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	if bytes.Contains(bytes.ToLower(head), []byte("<svg")) {
+		return FormatSVG
+	}
+
+	return FormatUnknown
+}
+
+// Normalize converts image data to a core EPUB image format when needed
+//
+// Core formats are returned unchanged. WebP, BMP and TIFF images are decoded
+// and re-encoded as JPEG when they are opaque photos (lossy WebP) or as PNG
+// otherwise. AVIF cannot be decoded with the pure-Go image packages and is
+// reported as an error.
+func Normalize(data []byte) ([]byte, Format, error) {
+	format := Sniff(data)
+	if format.Core {
+		return data, format, nil
+	}
+
+	var img image.Image
+	var err error
+	switch format {
+	case FormatWebP:
+		img, err = webp.Decode(bytes.NewReader(data))
+	case FormatBMP:
+		img, err = bmp.Decode(bytes.NewReader(data))
+	case FormatTIFF:
+		img, err = tiff.Decode(bytes.NewReader(data))
+	case FormatAVIF:
+		return data, format, fmt.Errorf("AVIF images cannot be decoded")
+	default:
+		return data, format, fmt.Errorf("unrecognized image format")
+	}
+	if err != nil {
+		return data, format, fmt.Errorf("error decoding %s image: %v", format.MediaType, err)
+	}
+
+	// Lossy WebP without transparency is a photo, keep it lossy
+	var buf bytes.Buffer
+	target := FormatPNG
+	if format == FormatWebP && isLossyWebP(data) && isOpaque(img) {
+		target = FormatJPEG
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 92})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return data, format, fmt.Errorf("error encoding %s image: %v", target.MediaType, err)
+	}
+
+	if logger.Debug {
+		slog.Debug("Converted image", "from", format.MediaType, "to", target.MediaType, "original", len(data), "converted", buf.Len())
+	}
+	return buf.Bytes(), target, nil
+}
+
+// isLossyWebP checks whether a WebP file uses the lossy VP8 encoding
+func isLossyWebP(data []byte) bool {
+	return len(data) >= 16 && bytes.Contains(data[12:min(len(data), 256)], []byte("VP8 "))
+}
+
+// isOpaque checks whether an image has no transparent pixels
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	_ "image/png" // Register the PNG decoder
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/ynsta/seireitranslations-epub/internal/logger"
+	"golang.org/x/image/draw"
+)
+
+// Profile describes how images are resized and recompressed for a target device
+type Profile struct {
+	Name         string
+	MaxDimension int
+	Quality      int
+	Grayscale    bool
+}
+
+// profiles holds the built-in image profiles
+var profiles = map[string]Profile{
+	"original": {Name: "original"},
+	"tablet":   {Name: "tablet", MaxDimension: 2048, Quality: 85},
+	"e-ink":    {Name: "e-ink", MaxDimension: 1448, Quality: 75, Grayscale: true},
+}
+
+// ProfileNames returns the names of the built-in image profiles
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProfileByName returns a built-in image profile
+func ProfileByName(name string) (Profile, error) {
+	profile, ok := profiles[strings.ToLower(name)]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown image profile %q (expected one of %s)", name, strings.Join(ProfileNames(), ", "))
+	}
+	return profile, nil
+}
+
+// IsOriginal returns true if the profile keeps images untouched
+func (p Profile) IsOriginal() bool {
+	return p.MaxDimension == 0 && p.Quality == 0 && !p.Grayscale
+}
+
+// Apply resizes and recompresses image data according to the profile
+//
+// It returns the new data and file extension, or the original data and an
+// empty extension when the image is left untouched, for example because it
+// is animated or because re-encoding would make the file larger.
+func (p Profile) Apply(data []byte) ([]byte, string, error) {
+	if p.IsOriginal() {
+		return data, "", nil
+	}
+
+	// Vector images don't need resizing
+	if Sniff(data) == FormatSVG {
+		return data, "", nil
+	}
+
+	// Animated GIFs would lose their animation
+	if g, err := gif.DecodeAll(bytes.NewReader(data)); err == nil && len(g.Image) > 1 {
+		return data, "", nil
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return data, "", fmt.Errorf("error decoding image: %v", err)
+	}
+
+	img = Resize(img, p.MaxDimension)
+	if p.Grayscale {
+		img = toGray(img)
+	}
+
+	var buf bytes.Buffer
+	quality := p.Quality
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+	if err := jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: quality}); err != nil {
+		return data, "", fmt.Errorf("error encoding image: %v", err)
+	}
+
+	// Keep the original when recompressing does not save anything
+	if buf.Len() >= len(data) {
+		if logger.Debug {
+			slog.Debug("Keeping original image, re-encoding would not shrink it", "format", format, "original", len(data), "encoded", buf.Len())
+		}
+		return data, "", nil
+	}
+
+	if logger.Debug {
+		slog.Debug("Recompressed image", "profile", p.Name, "format", format, "original", len(data), "encoded", buf.Len())
+	}
+	return buf.Bytes(), ".jpg", nil
+}
+
+// Resize scales an image down so that its largest side fits maxDimension
+func Resize(img image.Image, maxDimension int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if maxDimension <= 0 || (width <= maxDimension && height <= maxDimension) {
+		return img
+	}
+
+	if width >= height {
+		height = max(1, height*maxDimension/width)
+		width = maxDimension
+	} else {
+		width = max(1, width*maxDimension/height)
+		height = maxDimension
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
+
+// toGray converts an image to grayscale
+func toGray(img image.Image) image.Image {
+	bounds := img.Bounds()
+	gray := image.NewGray(bounds)
+	draw.Draw(gray, bounds, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(gray, bounds, img, bounds.Min, draw.Over)
+	return gray
+}
+
+// flatten composites transparent images onto a white background for JPEG encoding
+func flatten(img image.Image) image.Image {
+	if _, ok := img.(*image.Gray); ok {
+		return img
+	}
+
+	bounds := img.Bounds()
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, bounds, img, bounds.Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,82 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Manifest records how a book was built, so it can later be updated with new chapters
+//
+// It keeps the command-line arguments, the content of every post and the
+// source of every embedded image, so an update only downloads what changed.
+type Manifest struct {
+	Revision   int       `json:"revision"`
+	Modified   time.Time `json:"modified"`
+	Identifier string    `json:"identifier"`
+
+	// Dir is the working directory the arguments are relative to
+	Dir  string   `json:"dir"`
+	Args []string `json:"args"`
+
+	// CoverURL and Cover give the source and file name of a downloaded cover
+	CoverURL string `json:"cover_url,omitempty"`
+	Cover    string `json:"cover,omitempty"`
+
+	Posts  []Post  `json:"posts"`
+	Images []Image `json:"images"`
+}
+
+// Post is a blog post as extracted from its page, before any processing
+type Post struct {
+	Title     string    `json:"title"`
+	URL       string    `json:"url"`
+	Published time.Time `json:"published"`
+	HTML      string    `json:"html"`
+}
+
+// Image is an image embedded in the book, with the URLs it was found at
+type Image struct {
+	Name string   `json:"name"`
+	URLs []string `json:"urls"`
+}
+
+// Read reads a build manifest
+func Read(filename string) (*Manifest, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading build manifest: %v", err)
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("error parsing build manifest %s: %v", filename, err)
+	}
+	return m, nil
+}
+
+// Write writes the build manifest
+func (m *Manifest) Write(filename string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding build manifest: %v", err)
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing build manifest: %v", err)
+	}
+	return nil
+}
+
+// Find returns the post recorded for a URL list entry, or nil if the entry is new
+// or changed; it also returns nil on a nil manifest
+func (m *Manifest) Find(title string, url string) *Post {
+	if m == nil {
+		return nil
+	}
+	for i := range m.Posts {
+		if m.Posts[i].URL == url && m.Posts[i].Title == title {
+			return &m.Posts[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package processor
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// bloggerHosts lists the hosts serving Blogger and Google-hosted images
+var bloggerHosts = []string{
+	"blogger.googleusercontent.com",
+	".bp.blogspot.com",
+	".googleusercontent.com",
+}
+
+// bloggerSizeSegmentRe matches a size path segment such as /s320/, /s1600-h/ or /w400-h300-rw/
+var bloggerSizeSegmentRe = regexp.MustCompile(`^(s\d+|w\d+(-h\d+)?|h\d+)(-[a-z0-9]+)*$`)
+
+// bloggerSizeSuffixRe matches a size suffix such as =w400-h300 or =s1600-rw at the end of a path
+var bloggerSizeSuffixRe = regexp.MustCompile(`=(s\d+|w\d+(-h\d+)?|h\d+)(-[a-z0-9]+)*$`)
+
+// IsBloggerImageURL checks whether a URL points to a Blogger or googleusercontent image
+func IsBloggerImageURL(imgURL string) bool {
+	u, err := url.Parse(imgURL)
+	if err != nil {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, h := range bloggerHosts {
+		if host == strings.TrimPrefix(h, ".") || strings.HasSuffix(host, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveBloggerImageURL rewrites the size token of a Blogger image URL
+//
+// A size of 0 requests the original upload (s0). URLs that are not Blogger
+// images or carry no size token are returned unchanged.
+func ResolveBloggerImageURL(imgURL string, size int) string {
+	if !IsBloggerImageURL(imgURL) {
+		return imgURL
+	}
+
+	u, err := url.Parse(imgURL)
+	if err != nil {
+		return imgURL
+	}
+	token := fmt.Sprintf("s%d", size)
+
+	// Size suffix form: .../AVvXsE...=w400-h300
+	if bloggerSizeSuffixRe.MatchString(u.Path) {
+		u.Path = bloggerSizeSuffixRe.ReplaceAllString(u.Path, "="+token)
+		u.RawPath = ""
+		return u.String()
+	}
+
+	// Size path segment form: .../s320/image.jpg, replacing the last one found
+	segments := strings.Split(u.Path, "/")
+	for i := len(segments) - 2; i >= 0; i-- {
+		if bloggerSizeSegmentRe.MatchString(segments[i]) {
+			segments[i] = token
+			u.Path = strings.Join(segments, "/")
+			u.RawPath = ""
+			return u.String()
+		}
+	}
+
+	return imgURL
+}
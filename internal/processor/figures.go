@@ -0,0 +1,85 @@
+package processor
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/ynsta/seireitranslations-epub/internal/logger"
+)
+
+// Alt text generation modes for images without alt text
+const (
+	// AltTextCaption uses the figure caption, or the chapter title and image index without one
+	AltTextCaption = "caption"
+	// AltTextTitle always uses the chapter title and image index
+	AltTextTitle = "title"
+	// AltTextNone keeps the alt text found in the post, usually empty
+	AltTextNone = "none"
+)
+
+// convertCaptionTables turns Blogger caption tables into figure elements
+//
+// Blogger wraps captioned images in a table.tr-caption-container, with the
+// image in one cell and the caption in a td.tr-caption. This must run before
+// readability, which drops the classes identifying these tables.
+func (p *HTMLProcessor) convertCaptionTables(doc *goquery.Document) {
+	doc.Find("table").Each(func(i int, table *goquery.Selection) {
+		caption := table.Find(".tr-caption").First()
+		if !table.HasClass("tr-caption-container") && caption.Length() == 0 {
+			return
+		}
+
+		// The image, with the link to its full-size version if any
+		img := table.Find("img").First()
+		if img.Length() == 0 {
+			return
+		}
+		media := img
+		if link := img.ParentsUntilSelection(table).Filter("a").First(); link.Length() > 0 {
+			media = link
+		}
+		mediaHTML, err := goquery.OuterHtml(media)
+		if err != nil {
+			return
+		}
+
+		captionHTML := ""
+		if caption.Length() > 0 && strings.TrimSpace(caption.Text()) != "" {
+			captionHTML, _ = caption.Html()
+			captionHTML = "<figcaption>" + strings.TrimSpace(captionHTML) + "</figcaption>"
+		}
+
+		if logger.Debug {
+			slog.Debug("Converted caption table to figure", "caption", strings.TrimSpace(caption.Text()))
+		}
+		table.ReplaceWithHtml("<figure>" + mediaHTML + captionHTML + "</figure>")
+	})
+}
+
+// setAltText fills in the alt text of images that have none
+//
+// Each image in a chapter gets an index, counted across all the parts of the
+// chapter, so generated alt texts stay unique within the book.
+func (p *ImageProcessor) setAltText(doc *goquery.Document, title string) {
+	doc.Find("img").Each(func(i int, s *goquery.Selection) {
+		p.altIndex[title]++
+		if p.altText == AltTextNone {
+			return
+		}
+		if alt, _ := s.Attr("alt"); strings.TrimSpace(alt) != "" {
+			return
+		}
+
+		alt := ""
+		if p.altText == AltTextCaption {
+			caption := s.ParentsFiltered("figure").First().ChildrenFiltered("figcaption").First()
+			alt = strings.Join(strings.Fields(caption.Text()), " ")
+		}
+		if alt == "" {
+			alt = fmt.Sprintf("%s, illustration %d", title, p.altIndex[title])
+		}
+		s.SetAttr("alt", alt)
+	})
+}
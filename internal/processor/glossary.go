@@ -0,0 +1,150 @@
+package processor
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/ynsta/seireitranslations-epub/internal/logger"
+	"github.com/ynsta/seireitranslations-epub/pkg/utils"
+	"golang.org/x/net/html"
+)
+
+// GlossaryFilename is the internal filename of the glossary appendix section
+const GlossaryFilename = "glossary.xhtml"
+
+// glossaryTerm holds a compiled pattern for one glossary entry
+type glossaryTerm struct {
+	anchor  string
+	pattern *regexp.Regexp
+}
+
+// GlossaryLinker links the first occurrence of each glossary term to its entry
+type GlossaryLinker struct {
+	terms  []*glossaryTerm
+	linked map[string]bool
+}
+
+// NewGlossaryLinker creates a GlossaryLinker for the given glossary entries
+func NewGlossaryLinker(entries []utils.GlossaryEntry) *GlossaryLinker {
+	l := &GlossaryLinker{
+		linked: make(map[string]bool),
+	}
+
+	anchors := utils.GlossaryAnchors(entries)
+	for i, entry := range entries {
+		var alternatives []string
+		for _, term := range append([]string{entry.Term}, entry.Aliases...) {
+			alternatives = append(alternatives, termPattern(term))
+		}
+
+		pattern, err := regexp.Compile("(?i)" + strings.Join(alternatives, "|"))
+		if err != nil {
+			slog.Warn("Invalid glossary term, skipping", "term", entry.Term, "error", err)
+			continue
+		}
+
+		l.terms = append(l.terms, &glossaryTerm{
+			anchor:  anchors[i],
+			pattern: pattern,
+		})
+	}
+
+	return l
+}
+
+// termPattern builds a regular expression matching a term as a whole word
+func termPattern(term string) string {
+	pattern := regexp.QuoteMeta(term)
+	isWordChar := func(b byte) bool {
+		return b == '_' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+	}
+	if isWordChar(term[0]) {
+		pattern = `\b` + pattern
+	}
+	if isWordChar(term[len(term)-1]) {
+		pattern += `\b`
+	}
+	return pattern
+}
+
+// Link wraps the first occurrence in the book of each glossary term with a link to its entry
+func (l *GlossaryLinker) Link(content string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		slog.Error("Failed to parse HTML for glossary links", "error", err)
+		return content
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "a", "h1", "h2", "h3", "h4", "h5", "h6", "script", "style":
+				return
+			}
+		}
+
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			if c.Type == html.TextNode {
+				l.linkTextNode(c)
+			} else {
+				walk(c)
+			}
+			c = next
+		}
+	}
+	for _, n := range doc.Nodes {
+		walk(n)
+	}
+
+	result, err := doc.Html()
+	if err != nil {
+		slog.Error("Failed to render HTML after glossary linking", "error", err)
+		return content
+	}
+
+	return result
+}
+
+// linkTextNode splits a text node around the first unlinked glossary term it contains
+func (l *GlossaryLinker) linkTextNode(n *html.Node) {
+	for _, term := range l.terms {
+		if l.linked[term.anchor] {
+			continue
+		}
+
+		loc := term.pattern.FindStringIndex(n.Data)
+		if loc == nil {
+			continue
+		}
+		l.linked[term.anchor] = true
+
+		text := n.Data
+		link := &html.Node{
+			Type: html.ElementNode,
+			Data: "a",
+			Attr: []html.Attribute{
+				{Key: "epub:type", Val: "glossterm"},
+				{Key: "href", Val: GlossaryFilename + "#" + term.anchor},
+			},
+		}
+		link.AppendChild(&html.Node{Type: html.TextNode, Data: text[loc[0]:loc[1]]})
+
+		after := &html.Node{Type: html.TextNode, Data: text[loc[1]:]}
+		n.Data = text[:loc[0]]
+		n.Parent.InsertBefore(link, n.NextSibling)
+		n.Parent.InsertBefore(after, link.NextSibling)
+
+		if logger.Debug {
+			slog.Debug("Linked glossary term", "anchor", term.anchor, "text", text[loc[0]:loc[1]])
+		}
+
+		// The text on either side may contain other terms
+		l.linkTextNode(n)
+		l.linkTextNode(after)
+		return
+	}
+}
@@ -48,7 +48,7 @@ func sanitizeFilename(title string) string {
 	return safe
 }
 
-// CleanHTML removes inline styles and other unnecessary attributes
+// CleanHTML maps meaningful inline styles to semantic markup and removes other unnecessary attributes
 func (p *HTMLProcessor) CleanHTML(html string, contentTitle string) string {
 	// Debug logging for input HTML
 	if p.debug {
@@ -92,7 +92,16 @@ func (p *HTMLProcessor) CleanHTML(html string, contentTitle string) string {
 	// Remove sharethis-inline-reaction-buttons div
 	doc.Find(".sharethis-inline-reaction-buttons").Remove()
 
-	// Remove inline styles from all elements except images
+	// Keep Blogger image captions as figures before readability drops their classes
+	p.convertCaptionTables(doc)
+
+	// Detect chat and SNS message blocks while alignment styles are still available
+	p.markMessages(doc)
+
+	// Convert meaningful inline styles into semantic tags and classes
+	p.mapInlineStyles(doc)
+
+	// Remove the remaining inline styles from all elements except images
 	doc.Find("[style]").Each(func(i int, s *goquery.Selection) {
 		// Skip images - we want to keep their styles for responsive display
 		if !s.Is("img") {
@@ -166,7 +175,11 @@ func (p *HTMLProcessor) CleanHTML(html string, contentTitle string) string {
 	})
 
 	// Use readability to simplify the HTML structure
-	article, err := readability.FromReader(strings.NewReader(wrappedHTML), nil)
+	// Keep the classes set by the earlier processing stages
+	parser := readability.NewParser()
+	parser.ClassesToPreserve = append(parser.ClassesToPreserve, MessageClasses...)
+	parser.ClassesToPreserve = append(parser.ClassesToPreserve, StyleClasses...)
+	article, err := parser.Parse(strings.NewReader(wrappedHTML), nil)
 
 	var finalHtml string
 	if err != nil {
@@ -295,16 +308,12 @@ func (p *HTMLProcessor) ProcessChapterContent(title string, content string) stri
 		}
 	}
 
-	// Create chapter HTML with proper styling and minimal margins
-	chapterHTML := fmt.Sprintf(`<html>
-<head>
-    <title>%s</title>
-</head>
-<body class="chapter" style="margin:0; padding:0;">
-    <h2>%s</h2>
-    %s
-</body>
-</html>`, title, title, content)
+	// Create the chapter body with proper styling; go-epub provides the surrounding
+	// XHTML document, so only sanitized, well-formed body content is emitted
+	chapterHTML := fmt.Sprintf(`<section class="chapter" epub:type="chapter">
+<h2>%s</h2>
+%s
+</section>`, EscapeXHTML(title), SanitizeXHTML(content))
 
 	// Debug logging for final chapter HTML
 	if p.debug {
@@ -326,3 +335,46 @@ func (p *HTMLProcessor) ProcessChapterContent(title string, content string) stri
 
 	return chapterHTML
 }
+
+// ReplaceByID replaces the elements with the given ids in sanitized XHTML content
+//
+// The replacements map element ids to their new markup. Content holding none
+// of the ids is returned unchanged; otherwise it is sanitized again after the
+// replacement. It also returns the number of replaced elements.
+func ReplaceByID(content string, replacements map[string]string) (string, int) {
+	found := false
+	for id := range replacements {
+		if strings.Contains(content, id) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return content, 0
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		slog.Error("Failed to parse HTML for replacing elements", "error", err)
+		return content, 0
+	}
+
+	count := 0
+	doc.Find("[id]").Each(func(i int, s *goquery.Selection) {
+		id, _ := s.Attr("id")
+		if repl, ok := replacements[id]; ok {
+			s.ReplaceWithHtml(repl)
+			count++
+		}
+	})
+	if count == 0 {
+		return content, 0
+	}
+
+	body, err := doc.Find("body").Html()
+	if err != nil {
+		slog.Error("Failed to render HTML after replacing elements", "error", err)
+		return content, 0
+	}
+	return SanitizeXHTML(body), count
+}
@@ -1,24 +1,55 @@
 package processor
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"image"
 	"log/slog"
 	"net/url"
-	"path/filepath"
+	"path"
+	"sort"
 	"strings"
-	"time"
 
 	"github.com/PuerkitoBio/goquery"
-	"github.com/bmaupin/go-epub"
+	"github.com/ynsta/seireitranslations-epub/internal/book"
+	"github.com/ynsta/seireitranslations-epub/internal/downloader"
+	"github.com/ynsta/seireitranslations-epub/internal/imaging"
 	"github.com/ynsta/seireitranslations-epub/internal/logger"
+	"github.com/ynsta/seireitranslations-epub/internal/manifest"
+	"github.com/ynsta/seireitranslations-epub/pkg/utils"
+	"golang.org/x/net/html"
 )
 
 // ImageProcessor handles image processing for EPUB content
 type ImageProcessor struct {
-	downloader Downloader
-	tempDir    string
-	debug      bool
-	epub       *epub.Epub
+	downloader  Downloader
+	tempDir     string
+	debug       bool
+	book        *book.Book
+	byURL       map[string]string
+	byHash      map[string]string
+	reused      int
+	profile     imaging.Profile
+	savedBytes  int
+	bloggerSize int
+	sizes       map[string]image.Point
+	altText     string
+	altIndex    map[string]int
+	failed      []failedImage
+	images      []*imaging.BudgetImage
+}
+
+// failedImage records an image that could not be embedded, so it can be retried
+// at the end of the build and reported if it still fails
+type failedImage struct {
+	URL     string
+	Chapter string
+	Alt     string
+
+	// ID is the id of the placeholder element standing for the image
+	ID string
 }
 
 // Downloader interface defines methods needed for downloading files
@@ -28,23 +59,48 @@ type Downloader interface {
 }
 
 // NewImageProcessor creates a new ImageProcessor
-func NewImageProcessor(downloader Downloader, tempDir string, debug bool, epub *epub.Epub) *ImageProcessor {
+func NewImageProcessor(downloader Downloader, tempDir string, debug bool, b *book.Book) *ImageProcessor {
 	return &ImageProcessor{
 		downloader: downloader,
 		tempDir:    tempDir,
 		debug:      debug,
-		epub:       epub,
+		book:       b,
+		byURL:      make(map[string]string),
+		byHash:     make(map[string]string),
+		sizes:      make(map[string]image.Point),
+		altText:    AltTextCaption,
+		altIndex:   make(map[string]int),
 	}
 }
 
+// SetProfile sets the profile used to resize and recompress images
+func (p *ImageProcessor) SetProfile(profile imaging.Profile) {
+	p.profile = profile
+}
+
+// SetBloggerSize sets the image size requested from Blogger, 0 for the original upload
+func (p *ImageProcessor) SetBloggerSize(size int) {
+	p.bloggerSize = size
+}
+
+// SetAltText sets how alt text is generated for images without one
+func (p *ImageProcessor) SetAltText(mode string) {
+	p.altText = mode
+}
+
 // ProcessImages processes all images in the HTML content
-func (p *ImageProcessor) ProcessImages(content string, pageURL string) (string, error) {
+//
+// The chapter title is used to generate alt text for images without one.
+func (p *ImageProcessor) ProcessImages(content string, pageURL string, title string) (string, error) {
 	// Create a document from the HTML content
 	contentDoc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
 	if err != nil {
 		return "", fmt.Errorf("error parsing content HTML: %v", err)
 	}
 
+	// Describe images for screen readers, before failed images become placeholders
+	p.setAltText(contentDoc, title)
+
 	// Process full-size images from links first
 	contentDoc.Find("a").Each(func(i int, s *goquery.Selection) {
 		// Check if this is an image link
@@ -55,49 +111,26 @@ func (p *ImageProcessor) ProcessImages(content string, pageURL string) (string,
 				return
 			}
 
-			// Skip non-image links
+			// Skip non-image links; Blogger image URLs often have no extension
 			if !strings.Contains(fullImgSrc, ".jpg") && !strings.Contains(fullImgSrc, ".jpeg") &&
-				!strings.Contains(fullImgSrc, ".png") && !strings.Contains(fullImgSrc, ".gif") {
+				!strings.Contains(fullImgSrc, ".png") && !strings.Contains(fullImgSrc, ".gif") &&
+				!IsBloggerImageURL(fullImgSrc) && !strings.HasPrefix(fullImgSrc, "data:image/") {
 				return
 			}
 
 			// Make sure we have a valid URL
 			fullImgSrc = p.resolveURL(fullImgSrc, pageURL)
-
-			// Generate a unique filename for the image
-			imgExt := filepath.Ext(fullImgSrc)
-			if imgExt == "" {
-				imgExt = ".jpg" // Default extension
-			}
-			imgFilename := fmt.Sprintf("fullimg_%d_%d%s", time.Now().UnixNano(), i, imgExt)
-
-			// Download the image
-			if logger.Debug {
-				slog.Info("Downloading full-size image", "url", fullImgSrc)
-			}
-			imgData, err := p.downloader.DownloadFile(fullImgSrc, imgFilename)
-			if err != nil {
-				slog.Warn("Error downloading full-size image", "error", err)
+			if fullImgSrc == "" {
 				return
 			}
 
-			// Don't proceed if we got no data
-			if len(imgData) == 0 {
-				slog.Warn("No image data received for full-size image, skipping")
-				return
-			}
-
-			// Save image to a temporary file
-			tempImgPath, err := p.downloader.SaveToFile(imgData, imgFilename)
-			if err != nil {
-				slog.Warn("Error saving full-size image", "error", err)
-				return
+			// Download the image and add it to the EPUB
+			if logger.Debug {
+				slog.Info("Downloading full-size image", "url", downloader.ShortURL(fullImgSrc))
 			}
-
-			// Add image to EPUB
-			internalImgPath, err := p.epub.AddImage(tempImgPath, imgFilename)
+			internalImgPath, err := p.embedBestImage(fullImgSrc)
 			if err != nil {
-				slog.Warn("Error adding full-size image to EPUB", "error", err)
+				slog.Warn("Error processing full-size image", "url", downloader.ShortURL(fullImgSrc), "error", err)
 				return
 			}
 
@@ -140,46 +173,23 @@ func (p *ImageProcessor) ProcessImages(content string, pageURL string) (string,
 		// Make sure we have a valid URL (handle relative URLs)
 		imgSrc = p.resolveURL(imgSrc, pageURL)
 
-		// Skip image if URL is empty
+		// Drop image if URL is empty
 		if imgSrc == "" {
-			slog.Debug("Empty image URL, skipping")
+			slog.Debug("Empty image URL, removing")
+			s.Remove()
 			return
 		}
 
-		// Generate a unique filename for the image
-		imgExt := filepath.Ext(imgSrc)
-		if imgExt == "" {
-			imgExt = ".jpg" // Default extension
-		}
-		imgFilename := fmt.Sprintf("image_%d_%d%s", time.Now().UnixNano(), i, imgExt)
-
-		// Download the image
+		// Download the image and add it to the EPUB
 		if logger.Debug {
-			slog.Info("Downloading image", "url", imgSrc)
+			slog.Info("Downloading image", "url", downloader.ShortURL(imgSrc))
 		}
-		imgData, err := p.downloader.DownloadFile(imgSrc, imgFilename)
+		internalImgPath, err := p.embedBestImage(imgSrc)
 		if err != nil {
-			slog.Warn("Error downloading image", "error", err)
-			return
-		}
-
-		// Don't proceed if we got no data
-		if len(imgData) == 0 {
-			slog.Warn("No image data received, skipping")
-			return
-		}
-
-		// Save image to a temporary file
-		tempImgPath, err := p.downloader.SaveToFile(imgData, imgFilename)
-		if err != nil {
-			slog.Warn("Error saving image", "error", err)
-			return
-		}
-
-		// Add image to EPUB
-		internalImgPath, err := p.epub.AddImage(tempImgPath, imgFilename)
-		if err != nil {
-			slog.Warn("Error adding image to EPUB", "error", err)
+			// Keep no remote reference, the image is retried at the end of the build
+			slog.Warn("Error processing image, queued for retry", "url", downloader.ShortURL(imgSrc), "error", err)
+			alt, _ := s.Attr("alt")
+			s.ReplaceWithHtml(p.queueFailed(imgSrc, title, alt))
 			return
 		}
 
@@ -206,8 +216,387 @@ func (p *ImageProcessor) ProcessImages(content string, pageURL string) (string,
 	return processedHTML, nil
 }
 
+// embedBestImage embeds an image at the best available resolution
+//
+// Blogger image URLs are rewritten to request the configured size first,
+// falling back to the URL embedded in the post if that fails.
+func (p *ImageProcessor) embedBestImage(imgURL string) (string, error) {
+	bestURL := ResolveBloggerImageURL(imgURL, p.bloggerSize)
+	if bestURL != imgURL {
+		internalImgPath, err := p.embedImage(bestURL)
+		if err == nil {
+			return internalImgPath, nil
+		}
+		slog.Warn("Error fetching resized Blogger image, falling back to embedded URL", "url", bestURL, "error", err)
+	}
+
+	return p.embedImage(imgURL)
+}
+
+// embedImage downloads an image and adds it to the EPUB once per distinct content
+//
+// Images are keyed by the hash of their source URL for downloading and caching,
+// and by the hash of their bytes inside the EPUB, so the same illustration linked
+// from several parts is only stored once. Local files and data: URLs go through
+// the same path as remote images.
+func (p *ImageProcessor) embedImage(imgURL string) (string, error) {
+	// Reuse the image if this URL was already processed
+	if internalImgPath, ok := p.byURL[imgURL]; ok {
+		p.reused++
+		if logger.Debug {
+			slog.Debug("Reusing already embedded image", "url", downloader.ShortURL(imgURL), "path", internalImgPath)
+		}
+		return internalImgPath, nil
+	}
+
+	// Download the image, using a filename stable across runs for the debug cache
+	imgData, err := p.downloader.DownloadFile(imgURL, "src_"+hashString([]byte(imgURL)))
+	if err != nil {
+		return "", fmt.Errorf("error downloading image: %v", err)
+	}
+
+	// Don't proceed if we got no data
+	if len(imgData) == 0 {
+		return "", fmt.Errorf("no image data received")
+	}
+
+	// Reuse the image if the same content was already embedded from another URL
+	contentHash := hashString(imgData)
+	if internalImgPath, ok := p.byHash[contentHash]; ok {
+		p.byURL[imgURL] = internalImgPath
+		p.reused++
+		if logger.Debug {
+			slog.Debug("Reusing identical image content", "url", downloader.ShortURL(imgURL), "path", internalImgPath)
+		}
+		return internalImgPath, nil
+	}
+
+	// Detect the real image format and convert formats EPUB readers don't support
+	imgData, format, err := imaging.Normalize(imgData)
+	if err != nil {
+		return "", fmt.Errorf("error converting image: %v", err)
+	}
+	imgExt := format.Extension
+
+	// Resize and recompress the image according to the profile
+	if profiledData, profiledExt, err := p.profile.Apply(imgData); err != nil {
+		slog.Warn("Error applying image profile, keeping original", "url", downloader.ShortURL(imgURL), "error", err)
+	} else if profiledExt != "" {
+		p.savedBytes += len(imgData) - len(profiledData)
+		imgData, imgExt = profiledData, profiledExt
+	}
+
+	// Queue the image under a name derived from its content; images are
+	// added to the book together by AddToBook once all chapters are processed
+	imgFilename := "img_" + contentHash + imgExt
+	internalImgPath := book.ImagePath(imgFilename)
+	p.images = append(p.images, &imaging.BudgetImage{
+		Name:      imgFilename,
+		Data:      imgData,
+		Extension: imgExt,
+	})
+
+	p.byURL[imgURL] = internalImgPath
+	p.byHash[contentHash] = internalImgPath
+
+	// Remember the dimensions for full-page illustrations
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(imgData)); err == nil {
+		p.sizes[internalImgPath] = image.Point{X: cfg.Width, Y: cfg.Height}
+	}
+
+	return internalImgPath, nil
+}
+
+// queueFailed records an image that failed to download and returns its placeholder markup
+//
+// The placeholder has a unique id, so it can be found and replaced with
+// ReplaceByID in the final chapter XHTML if a retry succeeds.
+func (p *ImageProcessor) queueFailed(imgURL string, chapter string, alt string) string {
+	id := fmt.Sprintf("missing-image-%d", len(p.failed)+1)
+	p.failed = append(p.failed, failedImage{
+		URL:     imgURL,
+		Chapter: chapter,
+		Alt:     alt,
+		ID:      id,
+	})
+	return `<span class="image-missing" id="` + id + `">[Image unavailable]</span>`
+}
+
+// RetryFailed downloads the images that failed during the build once more
+//
+// It returns the XHTML replacing each placeholder whose image could now be
+// embedded, by placeholder id; images still failing stay as placeholders and are reported by
+// LogFailures.
+func (p *ImageProcessor) RetryFailed() map[string]string {
+	if len(p.failed) == 0 {
+		return nil
+	}
+
+	slog.Info("Retrying failed images", "count", len(p.failed))
+	replacements := make(map[string]string)
+	var stillFailed []failedImage
+	for _, img := range p.failed {
+		internalImgPath, err := p.embedBestImage(img.URL)
+		if err != nil {
+			slog.Warn("Image retry failed", "url", downloader.ShortURL(img.URL), "chapter", img.Chapter, "error", err)
+			stillFailed = append(stillFailed, img)
+			continue
+		}
+
+		replacements[img.ID] = `<img src="` + EscapeXHTML(internalImgPath) + `" alt="` +
+			EscapeXHTML(img.Alt) + `" style="max-width: 100%; height: auto;"/>`
+	}
+	p.failed = stillFailed
+
+	return replacements
+}
+
+// LogFailures reports the images that could not be embedded, with their chapter
+func (p *ImageProcessor) LogFailures() {
+	for _, img := range p.failed {
+		slog.Warn("Missing image", "url", downloader.ShortURL(img.URL), "chapter", img.Chapter)
+	}
+	if len(p.failed) > 0 {
+		slog.Warn("Some images could not be embedded and were replaced by placeholders", "count", len(p.failed))
+	}
+}
+
+// AddToBook saves the collected images and adds them to the book
+//
+// With a positive budget, the largest images are first downscaled until the
+// images fit in it. Shrunk images may change format, so the returned map gives
+// the new internal path of every image whose path changed.
+func (p *ImageProcessor) AddToBook(budget int64) map[string]string {
+	replacements := make(map[string]string)
+	if budget > 0 {
+		changes, fits := imaging.FitBudget(p.images, budget)
+		saved := 0
+		for _, change := range changes {
+			slog.Info("Downscaled image for size budget", "image", change.Name, "original", change.OriginalSize,
+				"size", change.Size, "width", change.Width, "height", change.Height)
+			saved += change.OriginalSize - change.Size
+		}
+		if fits {
+			slog.Info("Images fit the size budget", "budget", budget, "changed_images", len(changes), "saved_bytes", saved)
+		} else {
+			slog.Warn("Images could not be shrunk enough to fit the size budget", "budget", budget,
+				"changed_images", len(changes), "saved_bytes", saved)
+		}
+	}
+
+	for _, img := range p.images {
+		// Shrunk images may have been converted to another format
+		imgFilename := img.Name
+		if ext := path.Ext(imgFilename); ext != img.Extension {
+			imgFilename = strings.TrimSuffix(imgFilename, ext) + img.Extension
+			replacements[book.ImagePath(img.Name)] = book.ImagePath(imgFilename)
+		}
+
+		tempImgPath, err := p.downloader.SaveToFile(img.Data, imgFilename)
+		if err != nil {
+			slog.Warn("Error saving image", "image", imgFilename, "error", err)
+			continue
+		}
+		p.book.AddImage(tempImgPath, imgFilename)
+	}
+
+	return replacements
+}
+
+// AddEmbedded registers an image embedded by a previous build under its source URLs
+//
+// Posts referencing these URLs reuse the image instead of downloading it again.
+func (p *ImageProcessor) AddEmbedded(name string, urls []string, imgData []byte) {
+	internalImgPath := book.ImagePath(name)
+	for _, imgURL := range urls {
+		p.byURL[imgURL] = internalImgPath
+	}
+
+	// Images are named after the hash of their original content
+	contentHash := strings.TrimSuffix(strings.TrimPrefix(name, "img_"), path.Ext(name))
+	if _, ok := p.byHash[contentHash]; ok {
+		return
+	}
+	p.byHash[contentHash] = internalImgPath
+	p.images = append(p.images, &imaging.BudgetImage{
+		Name:      name,
+		Data:      imgData,
+		Extension: path.Ext(name),
+	})
+
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(imgData)); err == nil {
+		p.sizes[internalImgPath] = image.Point{X: cfg.Width, Y: cfg.Height}
+	}
+}
+
+// EmbeddedImages returns the images added to the book with the URLs they were found at
+//
+// The names take into account the format changes made by AddToBook.
+func (p *ImageProcessor) EmbeddedImages() []manifest.Image {
+	urls := make(map[string][]string)
+	for imgURL, internalImgPath := range p.byURL {
+		urls[internalImgPath] = append(urls[internalImgPath], imgURL)
+	}
+
+	var images []manifest.Image
+	for _, img := range p.images {
+		sort.Strings(urls[book.ImagePath(img.Name)])
+		images = append(images, manifest.Image{
+			Name: strings.TrimSuffix(img.Name, path.Ext(img.Name)) + img.Extension,
+			URLs: urls[book.ImagePath(img.Name)],
+		})
+	}
+	return images
+}
+
+// ExtractIllustrations removes the images that precede any text in processed content
+//
+// Color illustrations are posted either as image-only posts or as a run of
+// images at the top of a post. They are returned as full-page illustrations,
+// along with the remaining content, which is empty if the post had no text.
+func (p *ImageProcessor) ExtractIllustrations(content string) (string, []utils.Illustration) {
+	contentDoc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		slog.Error("Failed to parse HTML for illustrations", "error", err)
+		return content, nil
+	}
+
+	var illustrations []utils.Illustration
+	contentDoc.Find("body *").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		// Skip the content of figures already taken out with their image
+		if s.Closest("body").Length() == 0 {
+			return true
+		}
+
+		// Stop at the first element carrying text
+		if s.Is("p, h1, h2, h3, h4, h5, h6, li, blockquote, pre, td, dt, dd") && strings.TrimSpace(s.Text()) != "" {
+			return false
+		}
+		if hasOwnText(s) {
+			return false
+		}
+
+		// Only images embedded in the EPUB can become illustration pages
+		src, _ := s.Attr("src")
+		if !s.Is("img") || !strings.HasPrefix(src, "../") {
+			return true
+		}
+
+		// The caption of a figure goes with its image, and the figure is removed as a whole
+		caption := ""
+		figure := s.ParentsFiltered("figure").First()
+		if figure.Length() > 0 {
+			figcaption := figure.Find("figcaption")
+			caption = strings.Join(strings.Fields(figcaption.Text()), " ")
+			figcaption.Remove()
+		}
+
+		alt, _ := s.Attr("alt")
+		if alt == "" {
+			alt = caption
+		}
+		size := p.sizes[src]
+		illustrations = append(illustrations, utils.Illustration{
+			Src:     src,
+			Alt:     alt,
+			Caption: caption,
+			Width:   size.X,
+			Height:  size.Y,
+		})
+		s.Remove()
+		if figure.Length() > 0 && strings.TrimSpace(figure.Text()) == "" && figure.Find("img").Length() == 0 {
+			figure.Remove()
+		}
+		return true
+	})
+
+	if len(illustrations) == 0 {
+		return content, nil
+	}
+
+	// Remove the paragraphs left empty by the extracted images
+	contentDoc.Find("p, div, figure").Each(func(i int, s *goquery.Selection) {
+		if strings.TrimSpace(s.Text()) == "" && s.Find("img").Length() == 0 {
+			s.Remove()
+		}
+	})
+
+	if logger.Debug {
+		slog.Debug("Extracted full-page illustrations", "count", len(illustrations))
+	}
+
+	// Nothing is left if the post only contained illustrations
+	if strings.TrimSpace(contentDoc.Text()) == "" && contentDoc.Find("img").Length() == 0 {
+		return "", illustrations
+	}
+
+	remainingHTML, err := contentDoc.Html()
+	if err != nil {
+		slog.Error("Failed to render HTML after extracting illustrations", "error", err)
+		return content, nil
+	}
+
+	return remainingHTML, illustrations
+}
+
+// hasOwnText checks whether an element directly contains non-whitespace text
+func hasOwnText(s *goquery.Selection) bool {
+	for c := s.Get(0).FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode && strings.TrimSpace(c.Data) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ImageData returns the data of an image embedded in the EPUB by its path in the sections, or nil
+func (p *ImageProcessor) ImageData(src string) []byte {
+	for _, img := range p.images {
+		if book.ImagePath(img.Name) == src {
+			return img.Data
+		}
+	}
+	return nil
+}
+
+// LogSummary logs how many images were embedded and how many references reused them
+func (p *ImageProcessor) LogSummary() {
+	slog.Info("Image summary", "unique_images", len(p.byHash), "reused_references", p.reused,
+		"profile", p.profile.Name, "saved_bytes", p.savedBytes)
+}
+
+// hashString returns a short hexadecimal SHA-256 digest of data
+func hashString(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
 // resolveURL resolves a potentially relative URL against a base URL
+//
+// data: URLs are returned unchanged, and so are file:// URLs in local pages.
+// Local files are not allowed in remote pages: an empty URL is returned for
+// them, so a post cannot embed files from the machine running the build.
 func (p *ImageProcessor) resolveURL(imgSrc string, pageURL string) string {
+	lower := strings.ToLower(imgSrc)
+	if strings.HasPrefix(lower, "data:") {
+		return imgSrc
+	}
+	if !downloader.IsLocal(pageURL) {
+		resolved := p.resolveRelativeURL(imgSrc, pageURL)
+		if downloader.IsLocal(resolved) {
+			slog.Warn("Ignoring local image in remote post", "src", imgSrc, "page", pageURL)
+			return ""
+		}
+		return resolved
+	}
+	if strings.HasPrefix(lower, "file:") {
+		return imgSrc
+	}
+	return p.resolveRelativeURL(imgSrc, pageURL)
+}
+
+// resolveRelativeURL resolves a relative URL against a base URL
+func (p *ImageProcessor) resolveRelativeURL(imgSrc string, pageURL string) string {
 	if !strings.HasPrefix(imgSrc, "http://") && !strings.HasPrefix(imgSrc, "https://") {
 		// If it's a relative URL, try to resolve it against the page URL
 		baseURL, err := url.Parse(pageURL)
@@ -0,0 +1,74 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// flakyDownloader serves a PNG image for every URL, after failing a given number of times
+type flakyDownloader struct {
+	failures int
+	calls    int
+}
+
+func (d *flakyDownloader) DownloadFile(url string, filename string) ([]byte, error) {
+	d.calls++
+	if d.calls <= d.failures {
+		return nil, fmt.Errorf("HTTP status code: 503")
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 6))); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *flakyDownloader) SaveToFile(data []byte, filename string) (string, error) {
+	return filename, nil
+}
+
+func TestRetryFailedAfterSanitization(t *testing.T) {
+	p := NewImageProcessor(&flakyDownloader{failures: 1}, t.TempDir(), false, nil)
+	content, err := p.ProcessImages(`<p>Before</p><p><img src="https://example.com/image.png" alt="A &quot;picture&quot;"></p><p id="after">After</p>`,
+		"https://example.com/post.html", "Chapter 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(content, "image-missing") {
+		t.Fatalf("no placeholder for the failed image: %s", content)
+	}
+
+	// The placeholder goes through the chapter rendering before the retry
+	chapter := NewHTMLProcessor().ProcessChapterContent("Chapter 1", content)
+
+	replacements := p.RetryFailed()
+	if len(replacements) != 1 {
+		t.Fatalf("RetryFailed returned %d replacements, want 1", len(replacements))
+	}
+	recovered, count := ReplaceByID(chapter, replacements)
+	if count != 1 {
+		t.Errorf("ReplaceByID replaced %d elements, want 1", count)
+	}
+	if strings.Contains(recovered, "image-missing") || strings.Contains(recovered, "[Image unavailable]") {
+		t.Errorf("placeholder left in the chapter: %s", recovered)
+	}
+	if !strings.Contains(recovered, `<img src="../images/img_`) || !strings.Contains(recovered, `alt="A &quot;picture&quot;"`) {
+		t.Errorf("recovered image missing from the chapter: %s", recovered)
+	}
+
+	// The rest of the chapter is unchanged
+	for _, want := range []string{`<section class="chapter" epub:type="chapter">`, `<h2>Chapter 1</h2>`, `<p>Before</p>`, `<p id="after">After</p>`} {
+		if !strings.Contains(recovered, want) {
+			t.Errorf("%s missing from the chapter: %s", want, recovered)
+		}
+	}
+
+	// Content without the placeholders is returned as is
+	if unchanged, count := ReplaceByID(recovered, replacements); unchanged != recovered || count != 0 {
+		t.Errorf("ReplaceByID changed content without placeholders")
+	}
+}
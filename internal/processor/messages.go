@@ -0,0 +1,110 @@
+package processor
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/ynsta/seireitranslations-epub/internal/logger"
+)
+
+// MessageClasses lists the CSS classes emitted for chat and SNS message blocks
+var MessageClasses = []string{"msg", "msg-left", "msg-right", "msg-center", "msg-speaker"}
+
+// speakerPrefixRe matches a LINE-style speaker prefix such as "Nanako: " or "【Kyouya】"
+var speakerPrefixRe = regexp.MustCompile(`^\s*([A-Z][\p{L}'.\- ]{0,23}?\s*[:：]|【[^】]{1,24}】)\s*`)
+
+// messageLine holds the classification of a single paragraph
+type messageLine struct {
+	sel     *goquery.Selection
+	align   string
+	speaker string
+}
+
+// markMessages detects chat and SNS message exchanges and marks them with message classes
+//
+// It must run before inline styles are stripped since the alignment of each
+// message is only available in the original style attributes.
+func (p *HTMLProcessor) markMessages(doc *goquery.Document) {
+	var run []messageLine
+	var prev *goquery.Selection
+
+	doc.Find("p").Each(func(i int, s *goquery.Selection) {
+		// Paragraphs only belong to the same run when they are adjacent siblings
+		if prev != nil && (s.Prev().Length() == 0 || s.Prev().Get(0) != prev.Get(0)) {
+			p.flushMessageRun(run)
+			run = nil
+		}
+		prev = s
+
+		line := messageLine{sel: s, align: textAlign(s)}
+		if match := speakerPrefixRe.FindStringSubmatch(s.Text()); match != nil {
+			line.speaker = strings.TrimSpace(match[1])
+		}
+
+		if line.speaker == "" && line.align != "right" && line.align != "center" {
+			p.flushMessageRun(run)
+			run = nil
+			return
+		}
+		run = append(run, line)
+	})
+	p.flushMessageRun(run)
+}
+
+// flushMessageRun marks a run of paragraphs as messages if it looks like a chat exchange
+func (p *HTMLProcessor) flushMessageRun(run []messageLine) {
+	speakers, right := 0, 0
+	for _, line := range run {
+		if line.speaker != "" {
+			speakers++
+		}
+		if line.align == "right" {
+			right++
+		}
+	}
+
+	// A chat needs at least two messages with speakers, or right-aligned replies
+	// mixed with other messages; centered lines alone are usually scene breaks
+	if speakers < 2 && (right == 0 || right == len(run) || speakers+right < 2) {
+		return
+	}
+
+	for _, line := range run {
+		class := "msg msg-left"
+		switch line.align {
+		case "right":
+			class = "msg msg-right"
+		case "center":
+			if line.speaker == "" {
+				class = "msg msg-center"
+			}
+		}
+		line.sel.AddClass(class)
+
+		if line.speaker != "" {
+			wrapSpeaker(line.sel)
+		}
+	}
+
+	if logger.Debug {
+		slog.Debug("Marked message block", "messages", len(run), "speakers", speakers, "right_aligned", right)
+	}
+}
+
+// wrapSpeaker wraps the speaker prefix at the start of a paragraph in a speaker span
+func wrapSpeaker(s *goquery.Selection) {
+	html, err := s.Html()
+	if err != nil {
+		return
+	}
+
+	// Only rewrite when the prefix is plain text at the start of the paragraph
+	loc := speakerPrefixRe.FindStringSubmatchIndex(html)
+	if loc == nil || strings.ContainsAny(html[loc[2]:loc[3]], "<>") {
+		return
+	}
+
+	s.SetHtml(html[:loc[2]] + `<span class="msg-speaker">` + html[loc[2]:loc[3]] + `</span> ` + strings.TrimLeft(html[loc[3]:], " "))
+}
@@ -0,0 +1,191 @@
+package processor
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/ynsta/seireitranslations-epub/internal/logger"
+	"golang.org/x/net/html"
+)
+
+// ReplacementRule describes a single find-and-replace rule from a rules file
+type ReplacementRule struct {
+	Line    int
+	Regex   bool
+	Find    string
+	Replace string
+	Scope   string
+	Count   int
+
+	pattern *regexp.Regexp
+}
+
+// Replacer applies find-and-replace rules to the text of chapters
+type Replacer struct {
+	rules []*ReplacementRule
+}
+
+// LoadReplacementRules reads a rules file in the format "type::find::replace[::scope]"
+//
+// The type is either "literal" or "regex". The optional scope restricts the rule
+// to a chapter title or, when it starts with http:// or https://, to a source URL.
+// Empty lines and lines starting with # are ignored.
+func LoadReplacementRules(filename string) (*Replacer, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading rules file: %v", err)
+	}
+
+	return ParseReplacementRules(string(data))
+}
+
+// ParseReplacementRules parses the content of a rules file
+func ParseReplacementRules(data string) (*Replacer, error) {
+	r := &Replacer{}
+
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		parts := strings.Split(line, "::")
+		if len(parts) < 3 || len(parts) > 4 {
+			return nil, fmt.Errorf("invalid rule on line %d: %s (expected 'type::find::replace[::scope]')", i+1, line)
+		}
+
+		rule := &ReplacementRule{
+			Line:    i + 1,
+			Find:    parts[1],
+			Replace: parts[2],
+		}
+		if len(parts) == 4 {
+			rule.Scope = strings.TrimSpace(parts[3])
+		}
+
+		switch strings.ToLower(strings.TrimSpace(parts[0])) {
+		case "literal":
+		case "regex":
+			pattern, err := regexp.Compile(rule.Find)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex on line %d: %v", i+1, err)
+			}
+			rule.Regex = true
+			rule.pattern = pattern
+		default:
+			return nil, fmt.Errorf("invalid rule type on line %d: %q (expected 'literal' or 'regex')", i+1, parts[0])
+		}
+
+		if rule.Find == "" {
+			return nil, fmt.Errorf("empty search text on line %d", i+1)
+		}
+
+		r.rules = append(r.rules, rule)
+	}
+
+	return r, nil
+}
+
+// Rules returns the loaded rules along with their replacement counts
+func (r *Replacer) Rules() []*ReplacementRule {
+	return r.rules
+}
+
+// appliesTo checks whether the rule scope matches a chapter title or source URL
+func (rule *ReplacementRule) appliesTo(chapterTitle string, pageURL string) bool {
+	if rule.Scope == "" {
+		return true
+	}
+	if strings.HasPrefix(rule.Scope, "http://") || strings.HasPrefix(rule.Scope, "https://") {
+		return rule.Scope == pageURL
+	}
+	return rule.Scope == chapterTitle
+}
+
+// apply runs the rule on a piece of text and returns the result and the number of replacements
+func (rule *ReplacementRule) apply(text string) (string, int) {
+	if rule.Regex {
+		matches := rule.pattern.FindAllStringIndex(text, -1)
+		if len(matches) == 0 {
+			return text, 0
+		}
+		return rule.pattern.ReplaceAllString(text, rule.Replace), len(matches)
+	}
+
+	count := strings.Count(text, rule.Find)
+	if count == 0 {
+		return text, 0
+	}
+	return strings.ReplaceAll(text, rule.Find, rule.Replace), count
+}
+
+// Apply runs all matching rules on the text nodes of the HTML content
+func (r *Replacer) Apply(content string, chapterTitle string, pageURL string) string {
+	var active []*ReplacementRule
+	for _, rule := range r.rules {
+		if rule.appliesTo(chapterTitle, pageURL) {
+			active = append(active, rule)
+		}
+	}
+	if len(active) == 0 {
+		return content
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		slog.Error("Failed to parse HTML for replacements", "error", err)
+		return content
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style") {
+			return
+		}
+		if n.Type == html.TextNode {
+			for _, rule := range active {
+				var count int
+				n.Data, count = rule.apply(n.Data)
+				rule.Count += count
+				if count > 0 && logger.Debug {
+					slog.Debug("Applied replacement rule", "line", rule.Line, "chapter", chapterTitle, "count", count)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range doc.Nodes {
+		walk(n)
+	}
+
+	result, err := doc.Html()
+	if err != nil {
+		slog.Error("Failed to render HTML after replacements", "error", err)
+		return content
+	}
+
+	return result
+}
+
+// Report logs how many replacements each rule made
+func (r *Replacer) Report() {
+	total := 0
+	for _, rule := range r.rules {
+		kind := "literal"
+		if rule.Regex {
+			kind = "regex"
+		}
+		slog.Info("Replacement rule", "line", rule.Line, "type", kind, "find", rule.Find, "scope", rule.Scope, "count", rule.Count)
+		if rule.Count == 0 {
+			slog.Warn("Replacement rule never matched", "line", rule.Line, "find", rule.Find)
+		}
+		total += rule.Count
+	}
+	slog.Info("Replacement summary", "rules", len(r.rules), "replacements", total)
+}
@@ -0,0 +1,270 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseReplacementRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+
+		// want holds the expected rules as "type|find|replace|scope|line"
+		want []string
+
+		// err is a part of the expected error, if any
+		err string
+	}{
+		{
+			name:  "literal",
+			rules: "literal::Mr Smith::Mr. Smith",
+			want:  []string{"literal|Mr Smith|Mr. Smith||1"},
+		},
+		{
+			name:  "regex with a group",
+			rules: `regex::(\d+) yen::$1 円`,
+			want:  []string{`regex|(\d+) yen|$1 円||1`},
+		},
+		{
+			name:  "type is case insensitive",
+			rules: " Regex ::a+::b\nLITERAL::c::d",
+			want:  []string{"regex|a+|b||1", "literal|c|d||2"},
+		},
+		{
+			name:  "chapter scope",
+			rules: "literal::Mr Smith::Mr. Smith:: Chapter 1 ",
+			want:  []string{"literal|Mr Smith|Mr. Smith|Chapter 1|1"},
+		},
+		{
+			name:  "URL scope",
+			rules: "literal::a::b::https://example.com/2024/03/ch1.html",
+			want:  []string{"literal|a|b|https://example.com/2024/03/ch1.html|1"},
+		},
+		{
+			name:  "empty replacement",
+			rules: "literal::[TL note]::",
+			want:  []string{"literal|[TL note]|||1"},
+		},
+		{
+			name:  "comments, blank lines and CRLF",
+			rules: "# Names\r\n\r\n   \r\n  # indented comment\r\nliteral::a::b\r\n",
+			want:  []string{"literal|a|b||5"},
+		},
+		{
+			name:  "no rules",
+			rules: "",
+			want:  nil,
+		},
+		{
+			name:  "too few fields",
+			rules: "literal::a::b\nliteral::a",
+			err:   "invalid rule on line 2",
+		},
+		{
+			name:  "too many fields",
+			rules: "literal::a::b::scope::extra",
+			err:   "invalid rule on line 1",
+		},
+		{
+			name:  "unknown type",
+			rules: "glob::*::x",
+			err:   `invalid rule type on line 1: "glob"`,
+		},
+		{
+			name:  "invalid regex",
+			rules: "# first\nregex::(unclosed::x",
+			err:   "invalid regex on line 2",
+		},
+		{
+			name:  "empty search text",
+			rules: "literal::::x",
+			err:   "empty search text on line 1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := ParseReplacementRules(test.rules)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("error = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, rule := range r.Rules() {
+				kind := "literal"
+				if rule.Regex {
+					kind = "regex"
+				}
+				got = append(got, fmt.Sprintf("%s|%s|%s|%s|%d", kind, rule.Find, rule.Replace, rule.Scope, rule.Line))
+			}
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("rules:\ngot  %q\nwant %q", got, test.want)
+			}
+		})
+	}
+}
+
+// body returns the body of a document rendered by Apply, or content returned as is
+func body(content string) string {
+	content = strings.TrimPrefix(content, "<html><head></head><body>")
+	return strings.TrimSuffix(content, "</body></html>")
+}
+
+func TestReplacerApply(t *testing.T) {
+	const (
+		title = "Chapter 1"
+		url   = "https://example.com/2024/03/ch1.html"
+	)
+	tests := []struct {
+		name    string
+		rules   string
+		content string
+		want    string
+	}{
+		{
+			name:    "literal in every text node",
+			rules:   "literal::Smith::Smyth",
+			content: `<p>Smith said</p><p>to <b>Smith</b>: Smith!</p>`,
+			want:    `<p>Smyth said</p><p>to <b>Smyth</b>: Smyth!</p>`,
+		},
+		{
+			name:    "regex with a group",
+			rules:   `regex::(\d+) yen::$1 円`,
+			content: `<p>It cost 300 yen, not 20 yen.</p>`,
+			want:    `<p>It cost 300 円, not 20 円.</p>`,
+		},
+		{
+			name:    "attributes and tag names are kept",
+			rules:   "literal::foo::bar\nliteral::p::q",
+			content: `<p class="foo"><a href="/foo.html" title="foo">foo</a><img src="foo.png" alt="foo"/></p>`,
+			want:    `<p class="foo"><a href="/foo.html" title="foo">bar</a><img src="foo.png" alt="foo"/></p>`,
+		},
+		{
+			name:    "no match across markup",
+			rules:   "literal::Mr Smith::Mr. Smith",
+			content: `<p>Mr <i>Smith</i></p>`,
+			want:    `<p>Mr <i>Smith</i></p>`,
+		},
+		{
+			name:    "markup in the search text never matches",
+			rules:   "literal::<b>x</b>::y",
+			content: `<p><b>x</b></p>`,
+			want:    `<p><b>x</b></p>`,
+		},
+		{
+			name:    "replacement text is escaped",
+			rules:   "literal::and::<&>",
+			content: `<p>salt and pepper</p>`,
+			want:    `<p>salt &lt;&amp;&gt; pepper</p>`,
+		},
+		{
+			name:    "entities match their text",
+			rules:   "literal::Tom & Jerry::Tom and Jerry",
+			content: `<p>Tom &amp; Jerry</p>`,
+			want:    `<p>Tom and Jerry</p>`,
+		},
+		{
+			name:    "script and style are skipped",
+			rules:   "literal::x::y",
+			content: `<div><script>var x = 1;</script><style>x{}</style><p>x</p></div>`,
+			want:    `<div><script>var x = 1;</script><style>x{}</style><p>y</p></div>`,
+		},
+		{
+			name:    "rules apply in order",
+			rules:   "literal::a::b\nliteral::b::c",
+			content: `<p>a</p>`,
+			want:    `<p>c</p>`,
+		},
+		{
+			name:    "matching chapter scope",
+			rules:   "literal::a::b::Chapter 1",
+			content: `<p>a</p>`,
+			want:    `<p>b</p>`,
+		},
+		{
+			name:    "other chapter scope",
+			rules:   "literal::a::b::Chapter 2",
+			content: `<p>a</p>`,
+			want:    `<p>a</p>`,
+		},
+		{
+			name:    "matching URL scope",
+			rules:   "literal::a::b::https://example.com/2024/03/ch1.html",
+			content: `<p>a</p>`,
+			want:    `<p>b</p>`,
+		},
+		{
+			name:    "other URL scope",
+			rules:   "literal::a::b::https://example.com/2024/03/ch2.html",
+			content: `<p>a</p>`,
+			want:    `<p>a</p>`,
+		},
+		{
+			name:    "URL scope is not a chapter title",
+			rules:   "literal::a::b::Chapter 1.html",
+			content: `<p>a</p>`,
+			want:    `<p>a</p>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := ParseReplacementRules(test.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := body(r.Apply(test.content, title, url)); got != test.want {
+				t.Errorf("got  %s\nwant %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReplacerReport(t *testing.T) {
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	r, err := ParseReplacementRules("literal::a::b\nregex::c+::d\nliteral::never::x\nliteral::a::e::Chapter 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Apply(`<p>a a <i>a</i> cc c</p>`, "Chapter 1", "https://example.com/ch1.html")
+	r.Apply(`<p title="a">a</p>`, "Chapter 2", "https://example.com/ch2.html")
+
+	var counts []int
+	for _, rule := range r.Rules() {
+		counts = append(counts, rule.Count)
+	}
+	if want := []int{4, 2, 0, 0}; !slices.Equal(counts, want) {
+		t.Errorf("counts = %v, want %v", counts, want)
+	}
+
+	r.Report()
+	for _, want := range []string{
+		`msg="Replacement rule" line=1 type=literal find=a scope="" count=4`,
+		`msg="Replacement rule" line=2 type=regex find=c+ scope="" count=2`,
+		`level=WARN msg="Replacement rule never matched" line=3 find=never`,
+		`level=WARN msg="Replacement rule never matched" line=4 find=a`,
+		`msg="Replacement summary" rules=4 replacements=6`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("report is missing %s:\n%s", want, logs.String())
+		}
+	}
+	if strings.Contains(logs.String(), "never matched\" line=1") {
+		t.Errorf("a matching rule is reported as never matched:\n%s", logs.String())
+	}
+}
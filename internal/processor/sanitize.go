@@ -0,0 +1,282 @@
+package processor
+
+import (
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ynsta/seireitranslations-epub/internal/logger"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// allowedElements lists the elements kept in chapter content
+var allowedElements = map[string]bool{
+	"a": true, "abbr": true, "aside": true, "b": true, "blockquote": true, "br": true,
+	"caption": true, "cite": true, "code": true, "dd": true, "del": true, "dfn": true,
+	"div": true, "dl": true, "dt": true, "em": true, "figcaption": true, "figure": true,
+	"footer": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "i": true, "img": true, "ins": true, "kbd": true, "li": true,
+	"mark": true, "ol": true, "p": true, "pre": true, "q": true, "rp": true, "rt": true,
+	"ruby": true, "s": true, "section": true, "small": true, "span": true, "strong": true,
+	"sub": true, "sup": true, "table": true, "tbody": true, "td": true, "tfoot": true,
+	"th": true, "thead": true, "tr": true, "u": true, "ul": true,
+}
+
+// droppedElements lists the elements removed together with their content
+var droppedElements = map[string]bool{
+	"applet": true, "base": true, "button": true, "canvas": true, "embed": true,
+	"form": true, "frame": true, "frameset": true, "head": true, "iframe": true,
+	"input": true, "link": true, "math": true, "meta": true, "noscript": true,
+	"object": true, "script": true, "select": true, "style": true, "svg": true,
+	"template": true, "textarea": true, "title": true, "video": true, "audio": true,
+}
+
+// voidElements lists the allowed elements that never have content
+var voidElements = map[string]bool{
+	"br": true, "hr": true, "img": true,
+}
+
+// globalAttributes lists the attributes allowed on every element
+var globalAttributes = map[string]bool{
+	"id": true, "class": true, "title": true, "lang": true, "dir": true, "epub:type": true,
+}
+
+// elementAttributes lists the attributes allowed on specific elements
+var elementAttributes = map[string]map[string]bool{
+	"a":   {"href": true},
+	"img": {"src": true, "alt": true, "style": true},
+	"ol":  {"start": true},
+	"td":  {"colspan": true, "rowspan": true},
+	"th":  {"colspan": true, "rowspan": true},
+}
+
+// xhtmlSanitizer holds the state of a single sanitization pass
+type xhtmlSanitizer struct {
+	out strings.Builder
+	ids map[string]bool
+}
+
+// SanitizeXHTML renders an HTML fragment as well-formed XHTML using an EPUB-safe allowlist
+//
+// Scripts, frames, forms and similar elements are removed with their content,
+// unknown elements such as <font> are replaced by their content, and only
+// allowlisted attributes are kept, so event handlers, data-* attributes and
+// javascript: links are dropped.
+func SanitizeXHTML(fragment string) string {
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), context)
+	if err != nil {
+		slog.Error("Failed to parse HTML for sanitizing", "error", err)
+		return EscapeXHTML(fragment)
+	}
+
+	s := &xhtmlSanitizer{ids: make(map[string]bool)}
+	for _, n := range nodes {
+		s.render(n)
+	}
+
+	return s.out.String()
+}
+
+// render writes a node and its children as XHTML
+func (s *xhtmlSanitizer) render(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		s.out.WriteString(EscapeXHTML(n.Data))
+		return
+	case html.ElementNode:
+	case html.DocumentNode:
+		s.renderChildren(n)
+		return
+	default:
+		// Comments, doctypes and other nodes are dropped
+		return
+	}
+
+	tag := strings.ToLower(n.Data)
+	if droppedElements[tag] || n.Namespace != "" {
+		if logger.Debug {
+			slog.Debug("Sanitizer removed element", "tag", tag)
+		}
+		return
+	}
+
+	if !allowedElements[tag] {
+		// Keep the content of unknown elements such as <font>, <center> or <html>
+		s.renderChildren(n)
+		return
+	}
+
+	// Images without a source are useless
+	attrs := s.filterAttributes(tag, n.Attr)
+	if tag == "img" && !hasAttribute(attrs, "src") {
+		return
+	}
+
+	s.out.WriteString("<" + tag)
+	for _, attr := range attrs {
+		s.out.WriteString(" " + attr.Key + `="` + EscapeXHTML(attr.Val) + `"`)
+	}
+
+	if voidElements[tag] {
+		s.out.WriteString("/>")
+		return
+	}
+
+	s.out.WriteString(">")
+	s.renderChildren(n)
+	s.out.WriteString("</" + tag + ">")
+}
+
+// renderChildren writes all children of a node
+func (s *xhtmlSanitizer) renderChildren(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		s.render(c)
+	}
+}
+
+// filterAttributes keeps only the allowlisted attributes of an element
+func (s *xhtmlSanitizer) filterAttributes(tag string, attrs []html.Attribute) []html.Attribute {
+	var kept []html.Attribute
+	seen := make(map[string]bool)
+
+	for _, attr := range attrs {
+		key := strings.ToLower(attr.Key)
+		if attr.Namespace != "" {
+			key = strings.ToLower(attr.Namespace) + ":" + key
+		}
+		if seen[key] || (!globalAttributes[key] && !elementAttributes[tag][key]) {
+			continue
+		}
+
+		// Check the value as it will be written, without the characters EscapeXHTML removes
+		value := strings.TrimSpace(xmlCharsOnly(attr.Val))
+		switch key {
+		case "id":
+			// IDs must be valid XML names and unique within the document
+			if !isXMLName(value) || s.ids[value] {
+				continue
+			}
+			s.ids[value] = true
+		case "href", "src":
+			if !isSafeURL(value) {
+				continue
+			}
+		case "colspan", "rowspan", "start":
+			if value == "" || strings.Trim(value, "0123456789") != "" {
+				continue
+			}
+		}
+
+		seen[key] = true
+		kept = append(kept, html.Attribute{Key: key, Val: value})
+	}
+
+	// The alt attribute is required on images
+	if tag == "img" && !seen["alt"] {
+		kept = append(kept, html.Attribute{Key: "alt", Val: ""})
+	}
+
+	return kept
+}
+
+// hasAttribute checks whether an attribute is present in a list
+func hasAttribute(attrs []html.Attribute, key string) bool {
+	for _, attr := range attrs {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// isSafeURL rejects script and other unsafe URL schemes
+func isSafeURL(value string) bool {
+	if value == "" {
+		return false
+	}
+
+	// Remove whitespace and control characters that browsers ignore in schemes
+	scheme := strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(value))
+
+	for _, prefix := range []string{"javascript:", "vbscript:", "data:text", "file:"} {
+		if strings.HasPrefix(scheme, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// isXMLName checks whether a value is a valid XML name usable as an ID
+func isXMLName(value string) bool {
+	if value == "" {
+		return false
+	}
+	for i, r := range value {
+		isLetter := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_' || r > 0x7f
+		if i == 0 && !isLetter {
+			return false
+		}
+		if !isLetter && !(r >= '0' && r <= '9') && r != '-' && r != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// isXMLChar checks whether a rune is allowed in XML 1.0 documents
+func isXMLChar(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\r' ||
+		(r >= 0x20 && r <= 0xD7FF) ||
+		(r >= 0xE000 && r <= 0xFFFD) ||
+		(r >= 0x10000 && r <= 0x10FFFF)
+}
+
+// xmlCharsOnly removes invalid UTF-8 sequences and characters not allowed in XML
+func xmlCharsOnly(text string) string {
+	return strings.Map(func(r rune) rune {
+		if !isXMLChar(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(text, ""))
+}
+
+// EscapeXHTML escapes text for use in XHTML content and attribute values
+//
+// Invalid UTF-8 sequences and characters not allowed in XML are removed.
+func EscapeXHTML(text string) string {
+	var b strings.Builder
+	b.Grow(len(text))
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+		if r == utf8.RuneError && size == 1 {
+			continue
+		}
+
+		switch {
+		case r == '&':
+			b.WriteString("&amp;")
+		case r == '<':
+			b.WriteString("&lt;")
+		case r == '>':
+			b.WriteString("&gt;")
+		case r == '"':
+			b.WriteString("&quot;")
+		case r == '\'':
+			b.WriteString("&#39;")
+		case isXMLChar(r):
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
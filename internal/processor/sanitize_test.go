@@ -0,0 +1,70 @@
+package processor
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+// FuzzSanitizeXHTML checks that sanitized content is well-formed XML without active content
+func FuzzSanitizeXHTML(f *testing.F) {
+	seeds := []string{
+		`<p>Unclosed paragraph<p>Another <b>bold <i>italic</p>`,
+		`<div><span>Unclosed span<div>nested</span></div>`,
+		`Tom & Jerry &amp; friends &nbsp; &unknown; &#xZZ; &`,
+		`<p>outer<p>inner</p></p><p><p><p>deep</p>`,
+		`<p id="a">one</p><p id="a">two</p><p id="1bad">three</p>`,
+		`<img src="javascript:alert(1)" onerror="alert(1)"><a href=" JaVaScRiPt:alert(1)">x</a>`,
+		`<script>alert(1)</script><style>p{}</style><iframe src="x"></iframe>`,
+		`<p style="color:red" onclick="x()" data-x="1">styled</p><br><hr><img src="a.png">`,
+		`<span style="font-weight: bold;">Blogger</span><div class="separator"><a href="a.jpg"><img border="0" src="a.jpg"/></a></div>`,
+		"<p>\x00\x0bcontrol￾ chars</p>",
+		`<table><tr><td>cell<td>cell</table><ul><li>one<li>two</ul>`,
+		`<svg><script>alert(1)</script></svg><math><mi>x</mi></math>`,
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, fragment string) {
+		out := SanitizeXHTML(fragment)
+
+		decoder := xml.NewDecoder(strings.NewReader(`<root xmlns:epub="http://www.idpf.org/2007/ops">` + out + `</root>`))
+		decoder.Strict = true
+		for {
+			token, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("output is not well-formed XML: %v\ninput: %q\noutput: %q", err, fragment, out)
+			}
+
+			start, ok := token.(xml.StartElement)
+			if !ok {
+				continue
+			}
+			switch strings.ToLower(start.Name.Local) {
+			case "script", "iframe", "style":
+				t.Fatalf("output contains a %s element\ninput: %q\noutput: %q", start.Name.Local, fragment, out)
+			}
+			for _, attr := range start.Attr {
+				if strings.HasPrefix(strings.ToLower(attr.Name.Local), "on") {
+					t.Fatalf("output contains an event handler %s\ninput: %q\noutput: %q", attr.Name.Local, fragment, out)
+				}
+				if isScriptURL(attr.Value) {
+					t.Fatalf("output contains a javascript: URL\ninput: %q\noutput: %q", fragment, out)
+				}
+			}
+		}
+	})
+}
+
+// isScriptURL checks whether a URL runs script, the way browsers read it: tabs and
+// line breaks are ignored and leading spaces and control characters are trimmed
+func isScriptURL(value string) bool {
+	value = strings.NewReplacer("\t", "", "\n", "", "\r", "").Replace(value)
+	value = strings.TrimLeftFunc(value, func(r rune) bool { return r <= ' ' })
+	return strings.HasPrefix(strings.ToLower(value), "javascript:")
+}
@@ -0,0 +1,128 @@
+package processor
+
+import (
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/ynsta/seireitranslations-epub/internal/logger"
+	"golang.org/x/net/html"
+)
+
+// StyleClasses lists the CSS classes emitted when mapping inline alignment styles
+var StyleClasses = []string{"center", "right"}
+
+// inlineStyle parses the style attribute of an element into lower-cased properties
+func inlineStyle(s *goquery.Selection) map[string]string {
+	props := make(map[string]string)
+	style, _ := s.Attr("style")
+	for _, decl := range strings.Split(style, ";") {
+		name, value, ok := strings.Cut(decl, ":")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.ToLower(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "!important")))
+		if name != "" && value != "" {
+			props[name] = value
+		}
+	}
+	return props
+}
+
+// textAlign returns the alignment of an element from its style or align attribute
+func textAlign(s *goquery.Selection) string {
+	if align := inlineStyle(s)["text-align"]; align != "" {
+		return align
+	}
+	align, _ := s.Attr("align")
+	return strings.ToLower(align)
+}
+
+// isBoldWeight checks whether a font-weight value renders as bold
+func isBoldWeight(weight string) bool {
+	switch weight {
+	case "bold", "bolder":
+		return true
+	}
+	n, err := strconv.Atoi(weight)
+	return err == nil && n >= 600
+}
+
+// semanticTags returns the semantic tags matching the meaningful inline styles of an element
+func semanticTags(s *goquery.Selection, props map[string]string) []string {
+	var tags []string
+
+	if weight := props["font-weight"]; isBoldWeight(weight) && !s.Is("b, strong, h1, h2, h3, h4, h5, h6, th") {
+		tags = append(tags, "strong")
+	}
+
+	if fontStyle := props["font-style"]; (fontStyle == "italic" || fontStyle == "oblique") && !s.Is("i, em") {
+		tags = append(tags, "em")
+	}
+
+	decoration := props["text-decoration"] + " " + props["text-decoration-line"]
+	if strings.Contains(decoration, "line-through") && !s.Is("s, del, strike") {
+		tags = append(tags, "s")
+	}
+
+	return tags
+}
+
+// wrapChildren moves all children of a node into a new element of the given tag
+func wrapChildren(n *html.Node, tag string) {
+	wrapper := &html.Node{Type: html.ElementNode, Data: tag}
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		n.RemoveChild(c)
+		wrapper.AppendChild(c)
+		c = next
+	}
+	n.AppendChild(wrapper)
+}
+
+// mapInlineStyles converts meaningful inline CSS into semantic tags and classes
+//
+// Italics, bold and strikethrough become <em>, <strong> and <s>, and centered or
+// right-aligned blocks get the .center and .right classes. Everything else, such
+// as fonts, colors and sizes, is layout noise and is dropped with the style
+// attribute afterwards.
+func (p *HTMLProcessor) mapInlineStyles(doc *goquery.Document) {
+	doc.Find("[style], [align]").Each(func(i int, s *goquery.Selection) {
+		if s.Is("img") {
+			return
+		}
+
+		// Map alignment to classes on block elements, unless a message class already applies
+		if s.Is("p, div, h1, h2, h3, h4, h5, h6, blockquote") && !s.HasClass("msg") {
+			switch textAlign(s) {
+			case "center":
+				s.AddClass("center")
+			case "right":
+				s.AddClass("right")
+			}
+		}
+
+		// Wrap the content in semantic tags, innermost last
+		props := inlineStyle(s)
+		tags := semanticTags(s, props)
+		if len(tags) == 0 || strings.TrimSpace(s.Text()) == "" {
+			return
+		}
+
+		node := s.Get(0)
+		for j := len(tags) - 1; j >= 0; j-- {
+			wrapChildren(node, tags[j])
+		}
+
+		// A span only carrying the style is no longer needed
+		if s.Is("span") && len(node.Attr) == 1 && node.Attr[0].Key == "style" {
+			s.Children().First().Unwrap()
+		}
+
+		if logger.Debug {
+			slog.Debug("Mapped inline style to semantic tags", "tag", node.Data, "tags", tags)
+		}
+	})
+}
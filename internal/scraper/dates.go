@@ -0,0 +1,77 @@
+package scraper
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/ynsta/seireitranslations-epub/internal/logger"
+)
+
+// publishedSelectors lists where blog themes store the publication date of a post,
+// with the attribute holding a machine-readable date or "" to use the element text
+var publishedSelectors = []struct {
+	selector string
+	attr     string
+}{
+	{`meta[property="article:published_time"]`, "content"},
+	{`meta[itemprop="datePublished"]`, "content"},
+	{`[itemprop="datePublished"]`, "datetime"},
+	{`[itemprop="datePublished"]`, "title"},
+	{`abbr.published`, "title"},
+	{`time.published`, "datetime"},
+	{`time[datetime]`, "datetime"},
+	{`.date-header span`, ""},
+	{`.date-header`, ""},
+}
+
+// dateLayouts lists the date formats found in post pages
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05.999-07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+	"Monday, January 2, 2006",
+	"Monday, 2 January 2006",
+	"January 2, 2006",
+	"2 January 2006",
+	"Jan 2, 2006",
+	"01/02/2006",
+}
+
+// extractPublishedDate finds the publication date of a post, or returns the zero time
+func extractPublishedDate(doc *goquery.Document) time.Time {
+	for _, candidate := range publishedSelectors {
+		var published time.Time
+		doc.Find(candidate.selector).EachWithBreak(func(i int, s *goquery.Selection) bool {
+			value := strings.TrimSpace(s.Text())
+			if candidate.attr != "" {
+				value, _ = s.Attr(candidate.attr)
+			}
+			published = parseDate(value)
+			return published.IsZero()
+		})
+		if !published.IsZero() {
+			if logger.Debug {
+				slog.Debug("Found post publication date", "selector", candidate.selector, "date", published.Format(time.DateOnly))
+			}
+			return published
+		}
+	}
+	return time.Time{}
+}
+
+// parseDate parses a date in any of the known layouts
+func parseDate(value string) time.Time {
+	value = strings.Join(strings.Fields(value), " ")
+	if value == "" {
+		return time.Time{}
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
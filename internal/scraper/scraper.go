@@ -2,6 +2,7 @@ package scraper
 
 import (
 	"fmt"
+	"html"
 	"log/slog"
 	"net/http"
 	"strings"
@@ -22,6 +23,9 @@ func min(a, b int) int {
 // Content represents the extracted content from a web page
 type Content struct {
 	HTML string
+
+	// Published is the publication date of the post, or the zero time if not found
+	Published time.Time
 }
 
 // Scraper handles web scraping functionality
@@ -52,6 +56,9 @@ func (s *Scraper) ExtractContent(pageURL string, lineNum int) (Content, error) {
 		return Content{}, err
 	}
 
+	// Read the publication date before the page is reduced to its content
+	published := extractPublishedDate(doc)
+
 	// Try each extraction pattern to find content
 	contentDoc, err := s.extractContentWithPatterns(doc, pageURL, lineNum)
 	if err != nil {
@@ -85,7 +92,7 @@ func (s *Scraper) ExtractContent(pageURL string, lineNum int) (Content, error) {
 	// Small delay to be nice to the server
 	time.Sleep(500 * time.Millisecond)
 
-	return Content{HTML: processedHTML}, nil
+	return Content{HTML: processedHTML, Published: published}, nil
 }
 
 // fetchAndParseHTML downloads a webpage and parses the HTML
@@ -328,13 +335,13 @@ func (s *Scraper) convertDivsToParagraphs(doc *goquery.Document) {
 		}
 
 		// Get the HTML content
-		html, err := s.Html()
+		content, err := s.Html()
 		if err != nil {
 			return
 		}
 
 		// Check if it's an empty div with just a <br> tag
-		if strings.TrimSpace(html) == "<br>" || strings.TrimSpace(html) == "<br/>" || strings.TrimSpace(html) == "<br />" {
+		if strings.TrimSpace(content) == "<br>" || strings.TrimSpace(content) == "<br/>" || strings.TrimSpace(content) == "<br />" {
 			s.Remove()
 			if logger.Debug {
 				slog.Debug("Removed empty div with just a br tag")
@@ -353,8 +360,13 @@ func (s *Scraper) convertDivsToParagraphs(doc *goquery.Document) {
 
 			// If it has no divs, headers, or paragraphs, it's likely just text content
 			if childDivs == 0 && childHeaders == 0 && childParagraphs == 0 {
-				// Replace the div with a p element
-				s.ReplaceWithHtml(fmt.Sprintf("<p>%s</p>", html))
+				// Replace the div with a p element, keeping its inline style so that
+				// later processing stages can still see the original alignment
+				if style, ok := s.Attr("style"); ok && style != "" {
+					s.ReplaceWithHtml(fmt.Sprintf("<p style=\"%s\">%s</p>", html.EscapeString(style), content))
+				} else {
+					s.ReplaceWithHtml(fmt.Sprintf("<p>%s</p>", content))
+				}
 				if logger.Debug {
 					slog.Debug("Converted div to p element", "text_preview", text[:min(30, len(text))])
 				}
@@ -0,0 +1,108 @@
+package validate
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Severity levels of validation messages, as reported by epubcheck
+const (
+	Fatal   = "FATAL"
+	Error   = "ERROR"
+	Warning = "WARNING"
+)
+
+// Message is a single validation issue
+type Message struct {
+	Severity string
+	Code     string
+	Path     string
+	Line     int
+	Column   int
+	Text     string
+}
+
+// String formats the message like epubcheck does
+func (m Message) String() string {
+	location := m.Path
+	if m.Line > 0 {
+		location += fmt.Sprintf("(%d,%d)", m.Line, m.Column)
+	}
+	return fmt.Sprintf("%s(%s): %s: %s", m.Severity, m.Code, location, m.Text)
+}
+
+// Report holds the result of validating an EPUB file
+type Report struct {
+	File     string
+	Messages []Message
+}
+
+// add records a message for a file inside the EPUB, or for the EPUB itself if name is empty
+func (r *Report) add(severity, code, name string, line, column int, format string, args ...any) {
+	path := r.File
+	if name != "" {
+		path += "/" + name
+	}
+	r.Messages = append(r.Messages, Message{
+		Severity: severity,
+		Code:     code,
+		Path:     path,
+		Line:     line,
+		Column:   column,
+		Text:     fmt.Sprintf(format, args...),
+	})
+}
+
+// Count returns the number of messages with the given severity
+func (r *Report) Count(severity string) int {
+	n := 0
+	for _, m := range r.Messages {
+		if m.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// HasErrors returns true if the EPUB has fatal errors or errors
+func (r *Report) HasErrors() bool {
+	return r.Count(Fatal) > 0 || r.Count(Error) > 0
+}
+
+// Summary returns the epubcheck-like closing line of the report
+func (r *Report) Summary() string {
+	return fmt.Sprintf("Messages: %d fatal / %d errors / %d warnings", r.Count(Fatal), r.Count(Error), r.Count(Warning))
+}
+
+// Write prints all messages followed by a summary
+func (r *Report) Write(w io.Writer) {
+	sort.SliceStable(r.Messages, func(i, j int) bool {
+		return severityRank(r.Messages[i].Severity) < severityRank(r.Messages[j].Severity)
+	})
+	for _, m := range r.Messages {
+		fmt.Fprintln(w, m.String())
+	}
+
+	fmt.Fprintln(w)
+	switch {
+	case r.HasErrors():
+		fmt.Fprintln(w, "Check finished with errors")
+	case len(r.Messages) > 0:
+		fmt.Fprintln(w, "Check finished with warnings")
+	default:
+		fmt.Fprintln(w, "No errors or warnings detected.")
+	}
+	fmt.Fprintln(w, r.Summary())
+}
+
+// severityRank orders messages from the most to the least severe
+func severityRank(severity string) int {
+	switch severity {
+	case Fatal:
+		return 0
+	case Error:
+		return 1
+	}
+	return 2
+}
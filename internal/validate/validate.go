@@ -0,0 +1,450 @@
+package validate
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ynsta/seireitranslations-epub/internal/imaging"
+)
+
+// XML namespaces used by EPUB content documents
+const (
+	xlinkNamespace = "http://www.w3.org/1999/xlink"
+	svgNamespace   = "http://www.w3.org/2000/svg"
+)
+
+// rootfileRe finds the package document path in META-INF/container.xml
+var rootfileRe = regexp.MustCompile(`full-path="([^"]+)"`)
+
+// cssURLRe finds url() references in stylesheets
+var cssURLRe = regexp.MustCompile(`url\(\s*['"]?([^'")]+?)['"]?\s*\)`)
+
+// resourceAttributes lists the attributes referencing resources embedded in a page,
+// as opposed to hyperlinks
+var resourceAttributes = map[string]string{
+	"img": "src", "image": "href", "link": "href", "script": "src", "source": "src",
+	"audio": "src", "video": "src", "track": "src", "object": "data", "iframe": "src",
+}
+
+// opfPackage is the part of the package document that is validated
+type opfPackage struct {
+	UniqueIdentifier string `xml:"unique-identifier,attr"`
+	Metadata         struct {
+		Identifiers []struct {
+			ID string `xml:"id,attr"`
+		} `xml:"http://purl.org/dc/elements/1.1/ identifier"`
+		Titles    []string `xml:"http://purl.org/dc/elements/1.1/ title"`
+		Languages []string `xml:"http://purl.org/dc/elements/1.1/ language"`
+		Metas     []struct {
+			Property string `xml:"property,attr"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Items []opfItem `xml:"manifest>item"`
+	Spine struct {
+		Toc      string `xml:"toc,attr"`
+		ItemRefs []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+// opfItem is a manifest item
+type opfItem struct {
+	ID         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr"`
+	Fallback   string `xml:"fallback,attr"`
+}
+
+// hasProperty checks whether a manifest item declares a property
+func (i opfItem) hasProperty(property string) bool {
+	for _, p := range strings.Fields(i.Properties) {
+		if p == property {
+			return true
+		}
+	}
+	return false
+}
+
+// reference is a link from a document to another file of the EPUB
+type reference struct {
+	value     string
+	resource  bool
+	line, col int
+}
+
+// document holds what was found while scanning an XML document
+type document struct {
+	ids        map[string]bool
+	references []reference
+	hasSVG     bool
+	parsed     bool
+}
+
+// validator holds the state of a validation run
+type validator struct {
+	report   *Report
+	files    map[string][]byte
+	order    []string
+	opfPath  string
+	manifest map[string]opfItem
+	docs     map[string]*document
+}
+
+// File validates an EPUB file and returns a report of the issues found
+func File(filename string) *Report {
+	v := &validator{
+		report:   &Report{File: filepath.Base(filename)},
+		files:    make(map[string][]byte),
+		manifest: make(map[string]opfItem),
+		docs:     make(map[string]*document),
+	}
+
+	reader, err := zip.OpenReader(filename)
+	if err != nil {
+		v.report.add(Fatal, "PKG-008", "", 0, 0, "Unable to read file: %v", err)
+		return v.report
+	}
+	defer reader.Close()
+
+	v.checkMimetype(reader.File)
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		if err != nil {
+			v.report.add(Fatal, "PKG-008", f.Name, 0, 0, "Unable to read file: %v", err)
+			continue
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			v.report.add(Fatal, "PKG-008", f.Name, 0, 0, "Unable to read file: %v", err)
+			continue
+		}
+		v.files[f.Name] = data
+		v.order = append(v.order, f.Name)
+	}
+
+	if !v.checkPackage() {
+		return v.report
+	}
+	v.checkContent()
+	v.checkReferences()
+	v.checkProperties()
+
+	return v.report
+}
+
+// checkMimetype checks the mimetype entry that identifies EPUB archives
+func (v *validator) checkMimetype(files []*zip.File) {
+	if len(files) == 0 || files[0].Name != "mimetype" {
+		v.report.add(Error, "PKG-006", "", 0, 0, "Mimetype file entry is missing or is not the first file in the archive.")
+		return
+	}
+	if files[0].Method != zip.Store {
+		v.report.add(Error, "PKG-006", "mimetype", 0, 0, "Mimetype file should be stored uncompressed.")
+	}
+	rc, err := files[0].Open()
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+	if data, err := io.ReadAll(rc); err == nil && string(data) != "application/epub+zip" {
+		v.report.add(Error, "PKG-007", "mimetype", 0, 0, "Mimetype file should only contain the string \"application/epub+zip\".")
+	}
+}
+
+// checkPackage checks the container, the package document metadata, manifest and spine
+//
+// It returns false if the package document could not be read.
+func (v *validator) checkPackage() bool {
+	container, ok := v.files["META-INF/container.xml"]
+	if !ok {
+		v.report.add(Fatal, "RSC-002", "", 0, 0, "Required file META-INF/container.xml was not found in the container.")
+		return false
+	}
+	match := rootfileRe.FindSubmatch(container)
+	if match == nil {
+		v.report.add(Fatal, "OPF-002", "META-INF/container.xml", 0, 0, "The OPF file was not found in the container.")
+		return false
+	}
+	v.opfPath = string(match[1])
+	data, ok := v.files[v.opfPath]
+	if !ok {
+		v.report.add(Fatal, "OPF-002", "", 0, 0, "The OPF file %q was not found in the container.", v.opfPath)
+		return false
+	}
+
+	pkg := &opfPackage{}
+	if err := xml.Unmarshal(data, pkg); err != nil {
+		v.report.add(Fatal, "RSC-016", v.opfPath, 0, 0, "Fatal Error while parsing file: %v", err)
+		return false
+	}
+
+	// Metadata
+	found := false
+	for _, id := range pkg.Metadata.Identifiers {
+		found = found || id.ID == pkg.UniqueIdentifier
+	}
+	if !found {
+		v.report.add(Error, "OPF-030", v.opfPath, 0, 0, "The unique-identifier %q was not found.", pkg.UniqueIdentifier)
+	}
+	if len(pkg.Metadata.Titles) == 0 || strings.TrimSpace(pkg.Metadata.Titles[0]) == "" {
+		v.report.add(Error, "RSC-005", v.opfPath, 0, 0, "Element \"metadata\" is missing a non-empty \"dc:title\".")
+	}
+	if len(pkg.Metadata.Languages) == 0 || strings.TrimSpace(pkg.Metadata.Languages[0]) == "" {
+		v.report.add(Error, "RSC-005", v.opfPath, 0, 0, "Element \"metadata\" is missing a non-empty \"dc:language\".")
+	}
+	modified := false
+	for _, meta := range pkg.Metadata.Metas {
+		modified = modified || meta.Property == "dcterms:modified"
+	}
+	if !modified {
+		v.report.add(Error, "RSC-005", v.opfPath, 0, 0, "Package dcterms:modified meta element must occur exactly once.")
+	}
+
+	// Manifest
+	base := path.Dir(v.opfPath)
+	ids := make(map[string]opfItem)
+	hrefs := make(map[string]bool)
+	navCount := 0
+	for _, item := range pkg.Items {
+		if _, dup := ids[item.ID]; dup {
+			v.report.add(Error, "RSC-005", v.opfPath, 0, 0, "Duplicate manifest item id %q.", item.ID)
+		}
+		ids[item.ID] = item
+
+		name := resolve(base, item.Href)
+		if u, err := url.Parse(item.Href); err == nil {
+			name = resolve(base, u.Path)
+		}
+		if hrefs[name] {
+			v.report.add(Error, "OPF-074", v.opfPath, 0, 0, "Package resource %q is declared in several manifest item.", item.Href)
+		}
+		hrefs[name] = true
+		v.manifest[name] = item
+
+		if item.hasProperty("nav") {
+			navCount++
+		}
+
+		fileData, ok := v.files[name]
+		if !ok {
+			v.report.add(Error, "RSC-001", v.opfPath, 0, 0, "File %q could not be found.", item.Href)
+			continue
+		}
+		if strings.HasPrefix(item.MediaType, "image/") {
+			if sniffed := imaging.Sniff(fileData); sniffed != imaging.FormatUnknown && sniffed.MediaType != item.MediaType {
+				v.report.add(Error, "OPF-029", v.opfPath, 0, 0, "The file %q does not appear to match the media type %s, it looks like %s.", item.Href, item.MediaType, sniffed.MediaType)
+			}
+		}
+	}
+	if navCount != 1 {
+		v.report.add(Error, "RSC-005", v.opfPath, 0, 0, "Exactly one manifest item must declare the \"nav\" property (number of \"nav\" items: %d).", navCount)
+	}
+
+	// Files present in the archive but not declared
+	for _, name := range v.order {
+		if name == "mimetype" || name == v.opfPath || strings.HasPrefix(name, "META-INF/") || strings.HasSuffix(name, "/") {
+			continue
+		}
+		if !hrefs[name] {
+			v.report.add(Warning, "OPF-003", name, 0, 0, "Item %q exists in the EPUB, but is not declared in the OPF manifest.", name)
+		}
+	}
+
+	// Spine
+	if len(pkg.Spine.ItemRefs) == 0 {
+		v.report.add(Error, "RSC-005", v.opfPath, 0, 0, "The spine must contain at least one itemref.")
+	}
+	seen := make(map[string]bool)
+	for _, ref := range pkg.Spine.ItemRefs {
+		item, ok := ids[ref.IDRef]
+		if !ok {
+			v.report.add(Error, "OPF-049", v.opfPath, 0, 0, "Item id %q was not found in the manifest.", ref.IDRef)
+			continue
+		}
+		if seen[ref.IDRef] {
+			v.report.add(Error, "OPF-034", v.opfPath, 0, 0, "The spine contains multiple references to the manifest item with id %q.", ref.IDRef)
+		}
+		seen[ref.IDRef] = true
+		if item.MediaType != "application/xhtml+xml" && item.MediaType != "image/svg+xml" && item.Fallback == "" {
+			v.report.add(Error, "OPF-043", v.opfPath, 0, 0, "Spine item %q with non-standard media type %q has no fallback.", item.Href, item.MediaType)
+		}
+	}
+	if pkg.Spine.Toc != "" {
+		if _, ok := ids[pkg.Spine.Toc]; !ok {
+			v.report.add(Error, "OPF-049", v.opfPath, 0, 0, "Item id %q of the spine toc attribute was not found in the manifest.", pkg.Spine.Toc)
+		}
+	}
+
+	return true
+}
+
+// checkContent parses the XHTML, SVG, NCX and CSS files of the manifest
+func (v *validator) checkContent() {
+	names := make([]string, 0, len(v.manifest))
+	for name := range v.manifest {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		data, ok := v.files[name]
+		if !ok {
+			continue
+		}
+		switch v.manifest[name].MediaType {
+		case "application/xhtml+xml", "image/svg+xml", "application/x-dtbncx+xml":
+			v.docs[name] = v.scanXML(name, data)
+		case "text/css":
+			v.docs[name] = v.scanCSS(data)
+		}
+	}
+}
+
+// scanXML checks that a document is well-formed and collects its IDs and references
+func (v *validator) scanXML(name string, data []byte) *document {
+	doc := &document{ids: make(map[string]bool)}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = true
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			line, col := decoder.InputPos()
+			v.report.add(Fatal, "RSC-016", name, line, col, "Fatal Error while parsing file: %v", err)
+			return doc
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		line, col := decoder.InputPos()
+		if start.Name.Space == svgNamespace && start.Name.Local == "svg" {
+			doc.hasSVG = true
+		}
+
+		for _, attr := range start.Attr {
+			switch {
+			case attr.Name.Local == "id" && attr.Name.Space == "":
+				if doc.ids[attr.Value] {
+					v.report.add(Error, "RSC-005", name, line, col, "Duplicate ID %q.", attr.Value)
+				}
+				doc.ids[attr.Value] = true
+			case resourceAttributes[start.Name.Local] == attr.Name.Local && (attr.Name.Space == "" || attr.Name.Space == xlinkNamespace):
+				doc.references = append(doc.references, reference{value: attr.Value, resource: true, line: line, col: col})
+			case (start.Name.Local == "a" || start.Name.Local == "area") && attr.Name.Local == "href",
+				start.Name.Local == "content" && attr.Name.Local == "src":
+				doc.references = append(doc.references, reference{value: attr.Value, line: line, col: col})
+			}
+		}
+	}
+
+	doc.parsed = true
+	return doc
+}
+
+// scanCSS collects the url() references of a stylesheet
+func (v *validator) scanCSS(data []byte) *document {
+	doc := &document{ids: make(map[string]bool), parsed: true}
+	for _, match := range cssURLRe.FindAllSubmatch(data, -1) {
+		doc.references = append(doc.references, reference{value: string(match[1]), resource: true})
+	}
+	return doc
+}
+
+// checkReferences checks that references point to existing, local files and fragments
+func (v *validator) checkReferences() {
+	names := make([]string, 0, len(v.docs))
+	for name := range v.docs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, ref := range v.docs[name].references {
+			v.checkReference(name, ref)
+		}
+	}
+}
+
+// checkReference checks a single reference
+func (v *validator) checkReference(name string, ref reference) {
+	value := strings.TrimSpace(ref.value)
+	u, err := url.Parse(value)
+	if err != nil {
+		v.report.add(Error, "RSC-020", name, ref.line, ref.col, "%q is not a valid URL.", value)
+		return
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "":
+	case "http", "https":
+		if ref.resource {
+			v.report.add(Error, "RSC-006", name, ref.line, ref.col, "Remote resource reference is not allowed; resource %q must be located in the EPUB container.", value)
+		}
+		return
+	default:
+		// mailto:, data: and other schemes don't point inside the container
+		return
+	}
+
+	target := name
+	if u.Path != "" {
+		target = resolve(path.Dir(name), u.Path)
+	}
+	if _, ok := v.files[target]; !ok {
+		v.report.add(Error, "RSC-007", name, ref.line, ref.col, "Referenced resource %q could not be found in the EPUB.", value)
+		return
+	}
+	if _, ok := v.manifest[target]; !ok {
+		v.report.add(Error, "RSC-008", name, ref.line, ref.col, "Referenced resource %q is not declared in the OPF manifest.", value)
+		return
+	}
+
+	if u.Fragment != "" && !ref.resource {
+		if doc, ok := v.docs[target]; ok && doc.parsed && !doc.ids[u.Fragment] {
+			v.report.add(Error, "RSC-012", name, ref.line, ref.col, "Fragment identifier is not defined: %q.", value)
+		}
+	}
+}
+
+// checkProperties checks the manifest properties describing the content of documents
+func (v *validator) checkProperties() {
+	names := make([]string, 0, len(v.docs))
+	for name := range v.docs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		item := v.manifest[name]
+		if item.MediaType != "application/xhtml+xml" || !v.docs[name].parsed {
+			continue
+		}
+		if v.docs[name].hasSVG && !item.hasProperty("svg") {
+			v.report.add(Error, "OPF-014", v.opfPath, 0, 0, "The property \"svg\" should be declared in the OPF file for %q.", item.Href)
+		}
+		if !v.docs[name].hasSVG && item.hasProperty("svg") {
+			v.report.add(Error, "OPF-015", v.opfPath, 0, 0, "The property \"svg\" should not be declared in the OPF file for %q.", item.Href)
+		}
+	}
+}
+
+// resolve resolves an unescaped URL path relative to a directory of the container
+func resolve(dir string, p string) string {
+	return path.Clean(path.Join(dir, p))
+}
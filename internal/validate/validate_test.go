@@ -0,0 +1,317 @@
+package validate
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testFiles returns the files of a small valid EPUB, in archive order
+func testFiles(t *testing.T) ([]string, map[string]string) {
+	t.Helper()
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"mimetype": "application/epub+zip",
+		"META-INF/container.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="EPUB/package.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`,
+		"EPUB/package.opf": `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="pub-id">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="pub-id">urn:uuid:0b5d6a3c-0000-4000-8000-000000000000</dc:identifier>
+<dc:title>Test Book</dc:title>
+<dc:language>en</dc:language>
+<meta property="dcterms:modified">2024-03-01T00:00:00Z</meta>
+</metadata>
+<manifest>
+<item id="nav" href="xhtml/nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+<item id="ch1" href="xhtml/ch1.xhtml" media-type="application/xhtml+xml"/>
+<item id="img" href="images/a.png" media-type="image/png"/>
+</manifest>
+<spine>
+<itemref idref="nav"/>
+<itemref idref="ch1"/>
+</spine>
+</package>`,
+		"EPUB/xhtml/nav.xhtml": `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>Contents</title></head>
+<body>
+<nav epub:type="toc"><ol><li><a href="ch1.xhtml#start">Chapter</a></li></ol></nav>
+</body>
+</html>`,
+		"EPUB/xhtml/ch1.xhtml": `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>Chapter</title></head>
+<body>
+<h1 id="start">Chapter</h1>
+<p><img src="../images/a.png" alt=""/></p>
+</body>
+</html>`,
+		"EPUB/images/a.png": img.String(),
+	}
+	order := []string{"mimetype", "META-INF/container.xml", "EPUB/package.opf",
+		"EPUB/xhtml/nav.xhtml", "EPUB/xhtml/ch1.xhtml", "EPUB/images/a.png"}
+	return order, files
+}
+
+// writeEPUB writes the files to a zip archive, the mimetype stored uncompressed
+func writeEPUB(t *testing.T, order []string, files map[string]string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "test.epub")
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range order {
+		data, ok := files[name]
+		if !ok {
+			continue
+		}
+		method := zip.Deflate
+		if name == "mimetype" {
+			method = zip.Store
+		}
+		f, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// replaceIn returns a change replacing text in a file
+func replaceIn(name string, old string, new string) func(t *testing.T, order []string, files map[string]string) []string {
+	return func(t *testing.T, order []string, files map[string]string) []string {
+		if !strings.Contains(files[name], old) {
+			t.Fatalf("%q not found in %s", old, name)
+		}
+		files[name] = strings.Replace(files[name], old, new, 1)
+		return order
+	}
+}
+
+func TestValidEPUB(t *testing.T) {
+	order, files := testFiles(t)
+	report := File(writeEPUB(t, order, files))
+	if len(report.Messages) > 0 || report.HasErrors() {
+		t.Errorf("valid EPUB reported issues: %v", report.Messages)
+	}
+	if report.Summary() != "Messages: 0 fatal / 0 errors / 0 warnings" {
+		t.Errorf("summary = %q", report.Summary())
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, order []string, files map[string]string) []string
+
+		// message is the expected message, without its text
+		message string
+		errors  bool
+	}{
+		{
+			name: "mimetype not first",
+			change: func(t *testing.T, order []string, files map[string]string) []string {
+				return append(order[1:], order[0])
+			},
+			message: "ERROR(PKG-006): test.epub",
+			errors:  true,
+		},
+		{
+			name: "mimetype content",
+			change: func(t *testing.T, order []string, files map[string]string) []string {
+				files["mimetype"] = "application/zip"
+				return order
+			},
+			message: "ERROR(PKG-007): test.epub/mimetype",
+			errors:  true,
+		},
+		{
+			name: "missing container",
+			change: func(t *testing.T, order []string, files map[string]string) []string {
+				delete(files, "META-INF/container.xml")
+				return order
+			},
+			message: "FATAL(RSC-002): test.epub",
+			errors:  true,
+		},
+		{
+			name: "missing package document",
+			change: func(t *testing.T, order []string, files map[string]string) []string {
+				delete(files, "EPUB/package.opf")
+				return order
+			},
+			message: "FATAL(OPF-002): test.epub",
+			errors:  true,
+		},
+		{
+			name:    "missing title",
+			change:  replaceIn("EPUB/package.opf", "<dc:title>Test Book</dc:title>", ""),
+			message: "ERROR(RSC-005): test.epub/EPUB/package.opf",
+			errors:  true,
+		},
+		{
+			name:    "missing modification date",
+			change:  replaceIn("EPUB/package.opf", `<meta property="dcterms:modified">2024-03-01T00:00:00Z</meta>`, ""),
+			message: "ERROR(RSC-005): test.epub/EPUB/package.opf",
+			errors:  true,
+		},
+		{
+			name:    "unknown unique identifier",
+			change:  replaceIn("EPUB/package.opf", `unique-identifier="pub-id"`, `unique-identifier="other"`),
+			message: "ERROR(OPF-030): test.epub/EPUB/package.opf",
+			errors:  true,
+		},
+		{
+			name: "manifest item without file",
+			change: func(t *testing.T, order []string, files map[string]string) []string {
+				delete(files, "EPUB/images/a.png")
+				return order
+			},
+			message: "ERROR(RSC-001): test.epub/EPUB/package.opf",
+			errors:  true,
+		},
+		{
+			name:    "image media type",
+			change:  replaceIn("EPUB/package.opf", `media-type="image/png"`, `media-type="image/jpeg"`),
+			message: "ERROR(OPF-029): test.epub/EPUB/package.opf",
+			errors:  true,
+		},
+		{
+			name:    "spine item not in manifest",
+			change:  replaceIn("EPUB/package.opf", `<itemref idref="ch1"/>`, `<itemref idref="ch2"/>`),
+			message: "ERROR(OPF-049): test.epub/EPUB/package.opf",
+			errors:  true,
+		},
+		{
+			name:    "spine item repeated",
+			change:  replaceIn("EPUB/package.opf", `<itemref idref="ch1"/>`, `<itemref idref="ch1"/><itemref idref="ch1"/>`),
+			message: "ERROR(OPF-034): test.epub/EPUB/package.opf",
+			errors:  true,
+		},
+		{
+			name:    "empty spine",
+			change:  replaceIn("EPUB/package.opf", "<itemref idref=\"nav\"/>\n<itemref idref=\"ch1\"/>", ""),
+			message: "ERROR(RSC-005): test.epub/EPUB/package.opf",
+			errors:  true,
+		},
+		{
+			name: "image stripped from the book",
+			change: func(t *testing.T, order []string, files map[string]string) []string {
+				delete(files, "EPUB/images/a.png")
+				return replaceIn("EPUB/package.opf", `<item id="img" href="images/a.png" media-type="image/png"/>`, "")(t, order, files)
+			},
+			message: "ERROR(RSC-007): test.epub/EPUB/xhtml/ch1.xhtml(6,39)",
+			errors:  true,
+		},
+		{
+			name: "file not in manifest",
+			change: func(t *testing.T, order []string, files map[string]string) []string {
+				return replaceIn("EPUB/package.opf", `<item id="img" href="images/a.png" media-type="image/png"/>`, "")(t, order, files)
+			},
+			message: "ERROR(RSC-008): test.epub/EPUB/xhtml/ch1.xhtml(6,39)",
+			errors:  true,
+		},
+		{
+			name:    "remote image",
+			change:  replaceIn("EPUB/xhtml/ch1.xhtml", `src="../images/a.png"`, `src="https://example.com/a.png"`),
+			message: "ERROR(RSC-006): test.epub/EPUB/xhtml/ch1.xhtml(6,49)",
+			errors:  true,
+		},
+		{
+			name:    "remote link",
+			change:  replaceIn("EPUB/xhtml/ch1.xhtml", `<h1 id="start">Chapter</h1>`, `<h1 id="start"><a href="https://example.com/">Chapter</a></h1>`),
+			message: "",
+			errors:  false,
+		},
+		{
+			name:    "duplicate ID",
+			change:  replaceIn("EPUB/xhtml/ch1.xhtml", `<p><img`, `<p id="start"><img`),
+			message: "ERROR(RSC-005): test.epub/EPUB/xhtml/ch1.xhtml(6,15)",
+			errors:  true,
+		},
+		{
+			name:    "undefined fragment",
+			change:  replaceIn("EPUB/xhtml/nav.xhtml", `ch1.xhtml#start`, `ch1.xhtml#end`),
+			message: "ERROR(RSC-012): test.epub/EPUB/xhtml/nav.xhtml(5,54)",
+			errors:  true,
+		},
+		{
+			name:    "not well-formed",
+			change:  replaceIn("EPUB/xhtml/ch1.xhtml", `alt=""/></p>`, `alt=""></p>`),
+			message: "FATAL(RSC-016): test.epub/EPUB/xhtml/ch1.xhtml(6,42)",
+			errors:  true,
+		},
+		{
+			name:    "undeclared svg property",
+			change:  replaceIn("EPUB/xhtml/ch1.xhtml", `<p><img src="../images/a.png" alt=""/></p>`, `<svg xmlns="http://www.w3.org/2000/svg"><rect width="1" height="1"/></svg>`),
+			message: "ERROR(OPF-014): test.epub/EPUB/package.opf",
+			errors:  true,
+		},
+		{
+			name: "undeclared file",
+			change: func(t *testing.T, order []string, files map[string]string) []string {
+				files["EPUB/extra.txt"] = "extra"
+				return append(order, "EPUB/extra.txt")
+			},
+			message: "WARNING(OPF-003): test.epub/EPUB/extra.txt",
+			errors:  false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			order, files := testFiles(t)
+			order = test.change(t, order, files)
+			report := File(writeEPUB(t, order, files))
+
+			if report.HasErrors() != test.errors {
+				t.Errorf("HasErrors() = %v, want %v: %v", report.HasErrors(), test.errors, report.Messages)
+			}
+			if test.message == "" {
+				if len(report.Messages) > 0 {
+					t.Errorf("unexpected messages: %v", report.Messages)
+				}
+				return
+			}
+			found := false
+			for _, m := range report.Messages {
+				if strings.HasPrefix(m.String(), test.message+": ") {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("no message %q in %v", test.message, report.Messages)
+			}
+
+			// The report ends with the summary and the result of the check
+			var out bytes.Buffer
+			report.Write(&out)
+			if !strings.HasSuffix(out.String(), report.Summary()+"\n") {
+				t.Errorf("report does not end with the summary:\n%s", out.String())
+			}
+			if test.errors != strings.Contains(out.String(), "Check finished with errors") {
+				t.Errorf("report result does not match HasErrors:\n%s", out.String())
+			}
+		})
+	}
+}
@@ -3,7 +3,9 @@ package utils
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"unicode"
 )
 
 // ReadURLList reads a file containing a list of URLs in the format "Title::URL"
@@ -57,3 +59,157 @@ func GroupURLsByChapter(entries []URLEntry) map[string][]URLEntry {
 
 	return chapters
 }
+
+// GlossaryEntry represents a single term in a glossary definition file
+type GlossaryEntry struct {
+	Term       string
+	Aliases    []string
+	Definition string
+}
+
+// ReadGlossary reads a glossary file in the format "Term[|Alias...]::Definition"
+func ReadGlossary(filename string) ([]GlossaryEntry, error) {
+	// Read the file
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading glossary file: %v", err)
+	}
+
+	// Parse each line, skipping empty lines and comments
+	var entries []GlossaryEntry
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Split line into terms and definition
+		parts := strings.SplitN(line, "::", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			fmt.Printf("Invalid format for glossary line %d: %s (expected 'Term::Definition')\n", i+1, line)
+			continue
+		}
+
+		var terms []string
+		for _, term := range strings.Split(parts[0], "|") {
+			if term = strings.TrimSpace(term); term != "" {
+				terms = append(terms, term)
+			}
+		}
+		if len(terms) == 0 {
+			fmt.Printf("Invalid format for glossary line %d: %s (expected 'Term::Definition')\n", i+1, line)
+			continue
+		}
+
+		entries = append(entries, GlossaryEntry{
+			Term:       terms[0],
+			Aliases:    terms[1:],
+			Definition: strings.TrimSpace(parts[1]),
+		})
+	}
+
+	return entries, nil
+}
+
+// GlossaryAnchors returns the anchor IDs of glossary entries, in the same order
+//
+// The anchors are made of the letters and digits of each term, with a numeric
+// suffix for terms that would otherwise get the same anchor.
+func GlossaryAnchors(entries []GlossaryEntry) []string {
+	anchors := make([]string, len(entries))
+	used := make(map[string]bool)
+	for i, entry := range entries {
+		base := glossaryAnchor(entry.Term)
+		anchor := base
+		for n := 2; used[anchor]; n++ {
+			anchor = fmt.Sprintf("%s-%d", base, n)
+		}
+		used[anchor] = true
+		anchors[i] = anchor
+	}
+	return anchors
+}
+
+// glossaryAnchor returns the anchor ID of a glossary term, before collisions are resolved
+func glossaryAnchor(term string) string {
+	var b strings.Builder
+	b.WriteString("term-")
+	lastDash := true
+	for _, r := range strings.ToLower(term) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			lastDash = false
+		} else if !lastDash {
+			b.WriteRune('-')
+			lastDash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// Illustration represents a full-page illustration already embedded in the EPUB
+type Illustration struct {
+	Src     string
+	Alt     string
+	Caption string
+	Width   int
+	Height  int
+}
+
+// VolumeEntry represents a volume of an omnibus, with its own URL list and cover
+type VolumeEntry struct {
+	Title       string
+	URLListFile string
+	CoverURL    string
+}
+
+// ReadVolumeList reads an omnibus file in the format "Volume Title::URL list file[::Cover URL]"
+//
+// Relative URL list files, and relative cover paths, are resolved against the
+// folder of the omnibus file.
+func ReadVolumeList(filename string) ([]VolumeEntry, error) {
+	// Read the file
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading omnibus file: %v", err)
+	}
+	dir := filepath.Dir(filename)
+
+	// Parse each line, skipping empty lines and comments
+	var entries []VolumeEntry
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Split line into volume title, URL list file and optional cover
+		parts := strings.SplitN(line, "::", 3)
+		if len(parts) < 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			fmt.Printf("Invalid format for omnibus line %d: %s (expected 'Volume Title::URL list file[::Cover URL]')\n", i+1, line)
+			continue
+		}
+
+		entry := VolumeEntry{
+			Title:       strings.TrimSpace(parts[0]),
+			URLListFile: relativeTo(dir, strings.TrimSpace(parts[1])),
+		}
+		if len(parts) == 3 {
+			entry.CoverURL = strings.TrimSpace(parts[2])
+			if !strings.Contains(entry.CoverURL, ":") {
+				entry.CoverURL = relativeTo(dir, entry.CoverURL)
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// relativeTo resolves a relative path against a folder
+func relativeTo(dir string, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(dir, name)
+}